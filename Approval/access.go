@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"submitRequest":  {Roles: []string{"bank maker"}},
+	"approveRequest": {Roles: []string{"bank checker"}},
+	"rejectRequest":  {Roles: []string{"bank checker"}},
+	"getRequest":     {Roles: []string{"*"}},
+	"listRequests":   {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
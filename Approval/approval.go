@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type chainCode struct {
+}
+
+// Changes that need a second identity before they reach the ledger
+type requestTarget struct {
+	ChaincodeName string
+	Function      string
+	Fields        []string //fields an update request may change (args[1] of the target), nil for any arguments
+}
+
+var requestTypes = map[string]requestTarget{
+	"loan sanction":  {"loancc", "newLoanInfo", nil},
+	"business limit": {"businesscc", "updateBusinessInfo", []string{"business limit"}},
+	"business terms": {"businesscc", "updateBusinessInfo", []string{"max roi", "min roi", "tds rate", "state code"}},
+	"program limit":  {"programcc", "updateProgramInfo", []string{"program limit"}},
+	"program terms":  {"programcc", "updateProgramInfo", []string{"program roi", "discount percentage", "discount period", "program end date", "penal roi", "calendar id", "roll convention", "allocation order", "max extension days", "min tenor percent"}},
+	"ppr limit":      {"pprcc", "updatePPR", []string{"program business limit"}},
+	"ppr terms":      {"pprcc", "updatePPR", []string{"program business roi", "program business discount percentage", "program business discount period", "program business penal roi"}},
+	"loan extension": {"loancc", "extendLoan", nil},
+}
+
+// checkFields keeps an update request to the fields of its request type
+func checkFields(requestType string, target requestTarget, targetArgs []string) error {
+
+	if target.Fields == nil {
+		return nil
+	}
+	if len(targetArgs) != 3 {
+		return fmt.Errorf("A %s request takes the ID, the field and the value, given %d arguments", requestType, len(targetArgs))
+	}
+	field := strings.ToLower(targetArgs[1])
+	for _, allowed := range target.Fields {
+		if allowed == field {
+			return nil
+		}
+	}
+	return errors.New("A " + requestType + " request cannot change " + targetArgs[1] + ", allowed: " + strings.Join(target.Fields, ", "))
+}
+
+type requestEvent struct {
+	Action  string //submitted, approved or rejected
+	By      string
+	MSPID   string
+	TxID    string
+	Time    time.Time
+	Remarks string
+}
+
+type approvalRequest struct {
+	RequestType   string
+	ChaincodeName string
+	Function      string
+	Args          []string //arguments the target function is called with on approval
+	Status        string   //pending, approved or rejected
+	MakerID       string
+	Trail         []requestEvent
+}
+
+func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if function == "submitRequest" {
+		//Maker submits a sanction or limit change for approval
+		return submitRequest(stub, args)
+	} else if function == "approveRequest" {
+		//Checker approves and applies the change
+		return approveRequest(stub, args)
+	} else if function == "rejectRequest" {
+		//Checker rejects the change
+		return rejectRequest(stub, args)
+	} else if function == "getRequest" {
+		//Returns the request with its trail
+		return getRequest(stub, args)
+	} else if function == "listRequests" {
+		//Returns the requestIDs in a status
+		return listRequests(stub, args)
+	}
+	return shim.Error("No function named " + function + " in Approval")
+}
+
+func submitRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> RequestID
+		args[1] -> RequestType (loan sanction, business limit, business terms, program limit,
+		           program terms, ppr limit, ppr terms or loan extension)
+		args[2] -> JSON array of the arguments for the target function
+		args[3] -> Remarks
+	*/
+	if len(args) != 4 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in submitRequest (required:4) given:" + xLenStr)
+	}
+
+	ifExists, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ifExists != nil {
+		return shim.Error("RequestId " + args[0] + " exits. Cannot create new ID")
+	}
+
+	requestType := strings.ToLower(args[1])
+	target, ok := requestTypes[requestType]
+	if !ok {
+		return shim.Error("Invalid request type " + args[1])
+	}
+
+	targetArgs := []string{}
+	err = json.Unmarshal([]byte(args[2]), &targetArgs)
+	if err != nil {
+		return shim.Error("Unable to parse the request arguments (submitRequest):" + err.Error())
+	}
+	if len(targetArgs) == 0 {
+		return shim.Error("Request " + args[0] + " has no arguments")
+	}
+	err = checkFields(requestType, target, targetArgs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	event, makerID, err := newEvent(stub, "submitted", args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	request := approvalRequest{requestType, target.ChaincodeName, target.Function, targetArgs, "pending", makerID, []requestEvent{event}}
+	err = putRequest(stub, args[0], request, "")
+	if err != nil {
+		return shim.Error("submitRequest " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func approveRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> RequestID
+		args[1] -> Remarks
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in approveRequest (required:2) given:" + xLenStr)
+	}
+
+	request, event, err := checkRequest(stub, args[0], "approved", args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The change is applied only here, a failure leaves the request pending
+	chaincodeArgs := common.ToChaincodeArgs(append([]string{request.Function}, request.Args...)...)
+	response := stub.InvokeChaincode(request.ChaincodeName, chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error("Unable to apply request " + args[0] + ": " + response.Message)
+	}
+
+	request.Status = "approved"
+	request.Trail = append(request.Trail, event)
+	err = putRequest(stub, args[0], request, "pending")
+	if err != nil {
+		return shim.Error("approveRequest " + err.Error())
+	}
+	return shim.Success(response.Payload)
+}
+
+func rejectRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> RequestID
+		args[1] -> Remarks
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in rejectRequest (required:2) given:" + xLenStr)
+	}
+
+	request, event, err := checkRequest(stub, args[0], "rejected", args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	request.Status = "rejected"
+	request.Trail = append(request.Trail, event)
+	err = putRequest(stub, args[0], request, "pending")
+	if err != nil {
+		return shim.Error("rejectRequest " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// checkRequest loads a pending request and makes sure the checker is not its maker
+func checkRequest(stub shim.ChaincodeStubInterface, requestID string, action string, remarks string) (approvalRequest, requestEvent, error) {
+
+	request, err := readRequest(stub, requestID)
+	if err != nil {
+		return request, requestEvent{}, err
+	}
+	if request.Status != "pending" {
+		return request, requestEvent{}, errors.New("Request " + requestID + " is already " + request.Status)
+	}
+
+	event, checkerID, err := newEvent(stub, action, remarks)
+	if err != nil {
+		return request, event, err
+	}
+	if checkerID == request.MakerID {
+		return request, event, errors.New("Request " + requestID + " cannot be " + action + " by its maker")
+	}
+	return request, event, nil
+}
+
+func newEvent(stub shim.ChaincodeStubInterface, action string, remarks string) (requestEvent, string, error) {
+
+	// The caller is MSPID/name, as instrumentcc records its workflow events
+	by, err := common.CallerName(stub)
+	if err != nil {
+		return requestEvent{}, "", err
+	}
+	mspID := strings.SplitN(by, "/", 2)[0]
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return requestEvent{}, "", err
+	}
+	txTime := time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC()
+
+	return requestEvent{action, by, mspID, stub.GetTxID(), txTime, remarks}, by, nil
+}
+
+func readRequest(stub shim.ChaincodeStubInterface, requestID string) (approvalRequest, error) {
+
+	request := approvalRequest{}
+	requestBytes, err := stub.GetState(requestID)
+	if err != nil {
+		return request, err
+	} else if requestBytes == nil {
+		return request, errors.New("No data exists on this RequestID: " + requestID)
+	}
+	err = json.Unmarshal(requestBytes, &request)
+	return request, err
+}
+
+// putRequest writes the request and moves it in the Status~RequestID index
+func putRequest(stub shim.ChaincodeStubInterface, requestID string, request approvalRequest, oldStatus string) error {
+
+	requestBytes, _ := json.Marshal(request)
+	err := stub.PutState(requestID, requestBytes)
+	if err != nil {
+		return err
+	}
+
+	if oldStatus != "" {
+		oldKey, err := stub.CreateCompositeKey("Status~RequestID", []string{oldStatus, requestID})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(oldKey)
+		if err != nil {
+			return err
+		}
+	}
+	statusKey, err := stub.CreateCompositeKey("Status~RequestID", []string{request.Status, requestID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(statusKey, []byte{0x00})
+}
+
+func getRequest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getRequest (required:1) given:" + xLenStr)
+	}
+
+	request, err := readRequest(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	requestBytes, _ := json.Marshal(request)
+	return shim.Success(requestBytes)
+}
+
+func listRequests(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> Status (pending, approved or rejected)
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in listRequests (required:1) given:" + xLenStr)
+	}
+
+	statusIterator, err := stub.GetStateByPartialCompositeKey("Status~RequestID", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to fetch the requests (listRequests):" + err.Error())
+	}
+	defer statusIterator.Close()
+
+	requestIDs := []string{}
+	for statusIterator.HasNext() {
+		statusData, err := statusIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the requests (listRequests):" + err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(statusData.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		requestIDs = append(requestIDs, keyParts[1])
+	}
+
+	requestIDsBytes, _ := json.Marshal(requestIDs)
+	return shim.Success(requestIDsBytes)
+}
+
+func main() {
+	err := shim.Start(new(chainCode))
+	if err != nil {
+		fmt.Printf("Error starting Approval chaincode: %s\n", err)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"writeBankInfo":       {Roles: []string{"platform admin"}},
+	"getBankInfo":         {Roles: []string{"*"}},
+	"getWalletID":         {Roles: []string{"*"}},
+	"bankIDexists":        {Roles: []string{"*"}},
+	"addProvisionWallets": {Roles: []string{"platform admin"}},
+	"addContraWallet":     {Roles: []string{"platform admin"}},
+	"registerGST":         {Roles: []string{"platform admin"}},
+	"getStateCode":        {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
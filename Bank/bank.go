@@ -23,6 +23,13 @@ type bankInfo struct {
 	BankChargesWalletID   string //will take the values for the respective wallet from the user
 	BankLiabilityWalletID string //will take the values for the respective wallet from the user
 	TDSreceivableWalletID string //will take the values for the respective wallet from the user
+	ProvisionWalletID     string //provisions held against the loans by asset class
+	WriteOffWalletID      string //principal written off and not yet recovered
+	StateCode             string //GST state code of the bank's registration
+	CGSTWalletID          string //central GST payable on the charges
+	SGSTWalletID          string //state GST payable on the charges
+	IGSTWalletID          string //integrated GST payable on the charges
+	ContraWalletID        string //mirrors the trackers of the bank no business wallet mirrors, balancing their memo legs
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -41,6 +48,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "writeBankInfo" {
 		//Creates a new Bank Information
 		return writeBankInfo(stub, args)
@@ -53,6 +65,18 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	} else if function == "bankIDexists" {
 		//To check the BankId existence
 		return bankIDexists(stub, args[0])
+	} else if function == "addProvisionWallets" {
+		//Creates the provision and write-off wallets for an existing bank
+		return addProvisionWallets(stub, args)
+	} else if function == "addContraWallet" {
+		//Creates the contra wallet for an existing bank
+		return addContraWallet(stub, args)
+	} else if function == "registerGST" {
+		//Sets the GST state code of the bank and creates its GST wallets
+		return registerGST(stub, args)
+	} else if function == "getStateCode" {
+		//Returns the GST state code of the bank
+		return getStateCode(stub, args)
 	}
 	return shim.Error("No function named " + function + " in Banksssssssss")
 
@@ -60,9 +84,18 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 
 func writeBankInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 9 {
+	if (len(args) != 9) && (len(args) != 10) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in writeBankInfo (required:9) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in writeBankInfo (required:9 or 10) given:" + xLenStr)
+	}
+
+	//GST state code -> args[9] (optional, set later through registerGST)
+	stateCode := ""
+	if len(args) == 10 {
+		if !validStateCode(args[9]) {
+			return shim.Error("Invalid GST state code " + args[9])
+		}
+		stateCode = args[9]
 	}
 
 	//Checking Bank ID existence
@@ -116,8 +149,50 @@ func writeBankInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response
 	TDSreceivableWalletIDsha := hex.EncodeToString(md)
 	createWallet(stub, TDSreceivableWalletIDsha, "1000")
 
+	// Hashing ProvisionWalletID
+	ProvisionWalletStr := args[3] + "ProvisionWallet"
+	hash.Write([]byte(ProvisionWalletStr))
+	md = hash.Sum(nil)
+	ProvisionWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, ProvisionWalletIDsha, "0")
+
+	// Hashing WriteOffWalletID
+	WriteOffWalletStr := args[3] + "WriteOffWallet"
+	hash.Write([]byte(WriteOffWalletStr))
+	md = hash.Sum(nil)
+	WriteOffWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, WriteOffWalletIDsha, "0")
+
+	// Hashing CGSTWalletID
+	CGSTWalletStr := args[3] + "CGSTWallet"
+	hash.Write([]byte(CGSTWalletStr))
+	md = hash.Sum(nil)
+	CGSTWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, CGSTWalletIDsha, "0")
+
+	// Hashing SGSTWalletID
+	SGSTWalletStr := args[3] + "SGSTWallet"
+	hash.Write([]byte(SGSTWalletStr))
+	md = hash.Sum(nil)
+	SGSTWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, SGSTWalletIDsha, "0")
+
+	// Hashing IGSTWalletID
+	IGSTWalletStr := args[3] + "IGSTWallet"
+	hash.Write([]byte(IGSTWalletStr))
+	md = hash.Sum(nil)
+	IGSTWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, IGSTWalletIDsha, "0")
+
+	// Hashing ContraWalletID
+	ContraWalletStr := args[3] + "ContraWallet"
+	hash.Write([]byte(ContraWalletStr))
+	md = hash.Sum(nil)
+	ContraWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, ContraWalletIDsha, "0")
+
 	//args[0] -> bankID
-	bank := bankInfo{args[1], args[2], args[3], BankWalletIDsha, BankAssetWalletIDsha, BankChargesWalletIDsha, BankLiabilityWalletIDsha, TDSreceivableWalletIDsha}
+	bank := bankInfo{args[1], args[2], args[3], BankWalletIDsha, BankAssetWalletIDsha, BankChargesWalletIDsha, BankLiabilityWalletIDsha, TDSreceivableWalletIDsha, ProvisionWalletIDsha, WriteOffWalletIDsha, stateCode, CGSTWalletIDsha, SGSTWalletIDsha, IGSTWalletIDsha, ContraWalletIDsha}
 	bankBytes, err := json.Marshal(bank)
 	if err != nil {
 		return shim.Error("Unable to Marshal the json file " + err.Error())
@@ -211,11 +286,115 @@ func getWalletID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		walletID = bank.BankLiabilityWalletID
 	case "tds":
 		walletID = bank.TDSreceivableWalletID
+	case "provision":
+		walletID = bank.ProvisionWalletID
+	case "writeoff":
+		walletID = bank.WriteOffWalletID
+	case "cgst":
+		walletID = bank.CGSTWalletID
+	case "sgst":
+		walletID = bank.SGSTWalletID
+	case "igst":
+		walletID = bank.IGSTWalletID
+	case "contra":
+		walletID = bank.ContraWalletID
 	}
 
 	return shim.Success([]byte(walletID))
 }
 
+func addProvisionWallets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> bankID
+		Banks written before provisioning have no provision or write-off wallet
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in addProvisionWallets (required:1) given:" + xLenStr)
+	}
+	bankInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Unable to fetch the state" + err.Error())
+	}
+	if bankInfoBytes == nil {
+		return shim.Error("Data does not exist for " + args[0])
+	}
+	bank := bankInfo{}
+	err = json.Unmarshal(bankInfoBytes, &bank)
+	if err != nil {
+		return shim.Error("Uable to paser into the json format")
+	}
+	if bank.ProvisionWalletID != "" && bank.WriteOffWalletID != "" {
+		return shim.Error("Bank " + args[0] + " already has the provision and write-off wallets")
+	}
+
+	if bank.ProvisionWalletID == "" {
+		md := sha256.Sum256([]byte(bank.Bankcode + "ProvisionWallet"))
+		bank.ProvisionWalletID = hex.EncodeToString(md[:])
+		response := createWallet(stub, bank.ProvisionWalletID, "0")
+		if response.Status != shim.OK {
+			return response
+		}
+	}
+	if bank.WriteOffWalletID == "" {
+		md := sha256.Sum256([]byte(bank.Bankcode + "WriteOffWallet"))
+		bank.WriteOffWalletID = hex.EncodeToString(md[:])
+		response := createWallet(stub, bank.WriteOffWalletID, "0")
+		if response.Status != shim.OK {
+			return response
+		}
+	}
+
+	bankBytes, _ := json.Marshal(bank)
+	err = stub.PutState(args[0], bankBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func addContraWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> bankID
+		Banks written before the memo legs were balanced have no contra wallet
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in addContraWallet (required:1) given:" + xLenStr)
+	}
+	bankInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Unable to fetch the state" + err.Error())
+	}
+	if bankInfoBytes == nil {
+		return shim.Error("Data does not exist for " + args[0])
+	}
+	bank := bankInfo{}
+	err = json.Unmarshal(bankInfoBytes, &bank)
+	if err != nil {
+		return shim.Error("Uable to paser into the json format")
+	}
+	if bank.ContraWalletID != "" {
+		return shim.Error("Bank " + args[0] + " already has the contra wallet")
+	}
+
+	md := sha256.Sum256([]byte(bank.Bankcode + "ContraWallet"))
+	bank.ContraWalletID = hex.EncodeToString(md[:])
+	response := createWallet(stub, bank.ContraWalletID, "0")
+	if response.Status != shim.OK {
+		return response
+	}
+
+	bankBytes, _ := json.Marshal(bank)
+	err = stub.PutState(args[0], bankBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
 func main() {
 	err := shim.Start(new(chainCode))
 	if err != nil {
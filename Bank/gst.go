@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// validStateCode accepts the two digit state codes that begin a GSTIN
+func validStateCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	num, err := strconv.Atoi(code)
+	return err == nil && num > 0
+}
+
+func registerGST(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> bankID
+		args[1] -> GST state code
+		Banks written before GST have no state code or GST wallets
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in registerGST (required:2) given:" + xLenStr)
+	}
+	if !validStateCode(args[1]) {
+		return shim.Error("Invalid GST state code " + args[1])
+	}
+
+	bankInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Unable to fetch the state" + err.Error())
+	}
+	if bankInfoBytes == nil {
+		return shim.Error("Data does not exist for " + args[0])
+	}
+	bank := bankInfo{}
+	err = json.Unmarshal(bankInfoBytes, &bank)
+	if err != nil {
+		return shim.Error("Uable to paser into the json format")
+	}
+	bank.StateCode = args[1]
+
+	gstWallets := []struct {
+		suffix   string
+		walletID *string
+	}{
+		{"CGSTWallet", &bank.CGSTWalletID},
+		{"SGSTWallet", &bank.SGSTWalletID},
+		{"IGSTWallet", &bank.IGSTWalletID},
+	}
+	for _, wallet := range gstWallets {
+		if *wallet.walletID != "" {
+			continue
+		}
+		md := sha256.Sum256([]byte(bank.Bankcode + wallet.suffix))
+		*wallet.walletID = hex.EncodeToString(md[:])
+		response := createWallet(stub, *wallet.walletID, "0")
+		if response.Status != shim.OK {
+			return response
+		}
+	}
+
+	bankBytes, _ := json.Marshal(bank)
+	err = stub.PutState(args[0], bankBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getStateCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getStateCode (required:1) given:" + xLenStr)
+	}
+	bankInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Unable to fetch the state" + err.Error())
+	}
+	if bankInfoBytes == nil {
+		return shim.Error("Data does not exist for " + args[0])
+	}
+	bank := bankInfo{}
+	err = json.Unmarshal(bankInfoBytes, &bank)
+	if err != nil {
+		return shim.Error("Uable to paser into the json format")
+	}
+	if bank.StateCode == "" {
+		return shim.Error("Bank " + args[0] + " has no GST state code, run registerGST")
+	}
+	return shim.Success([]byte(bank.StateCode))
+}
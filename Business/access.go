@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"putNewBusinessInfo":  {Roles: []string{"platform admin", "bank maker"}},
+	"getBusinessInfo":     {Roles: []string{"*"}},
+	"getWalletID":         {Roles: []string{"*"}},
+	"busIDexists":         {Roles: []string{"*"}},
+	"updateBusinessInfo":  {Chaincodes: []string{"approvalcc"}},
+	"getHistory":          {Roles: []string{"*"}},
+	"updateExposure":      {Chaincodes: []string{"loancc", "approvalcc", "txncc", "disbursementcc", "repaycc"}},
+	"getBusinessHeadroom": {Roles: []string{"*"}},
+	"recordTDS":           {Chaincodes: []string{"txncc"}},
+	"getTDSReceivable":    {Roles: []string{"*"}},
+	"addContraWallet":     {Roles: []string{"platform admin"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
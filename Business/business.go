@@ -10,6 +10,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -18,14 +19,18 @@ type chainCode struct {
 type businessInfo struct {
 	BusinessName                         string
 	BusinessAcNo                         string
-	BusinessLimit                        int64
+	BusinessLimit                        int64  //minor units
 	BusinessWalletID                     string //will take the values for the respective wallet from the user
 	BusinessLoanWalletID                 string //will take the values for the respective wallet from the user
 	BusinessLiabilityWalletID            string //will take the values for the respective wallet from the user
 	MaxROI                               int64
 	MinROI                               int64
-	BusinessPrincipalOutstandingWalletID string //will take the values for the respective wallet from the user
-	BusinessInterestOutstandingWalletID  string //will take the values for the respective wallet from the user
+	BusinessPrincipalOutstandingWalletID string  //will take the values for the respective wallet from the user
+	BusinessInterestOutstandingWalletID  string  //will take the values for the respective wallet from the user
+	Currency                             string  //currency of BusinessLimit
+	TDSRate                              float64 //percent deducted at source on the interest the business pays
+	StateCode                            string  //GST state code of the business's registration
+	ContraWalletID                       string  //mirrors the trackers of the business no bank wallet mirrors, balancing their memo legs
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -43,6 +48,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "putNewBusinessInfo" {
 		//Creates a new Business Information
 		return putNewBusinessInfo(stub, args)
@@ -56,20 +66,47 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		//To check the BusinessId existence
 		return busIDexists(stub, args[0])
 	} else if function == "updateBusinessInfo" {
-		//Updates Business Limit / MAX ROI / MAX ROI if required
+		//Updates Business Limit / MAX ROI / MAX ROI / TDS rate / State code if required
 		return updateBusinessInfo(stub, args)
+	} else if function == "getHistory" {
+		//Returns every past version of the record with its submitter
+		return getHistory(stub, args)
+	} else if function == "updateExposure" {
+		//Moves the business exposure on sanction, disbursement and repayment
+		return updateExposure(stub, args)
+	} else if function == "getBusinessHeadroom" {
+		//Returns the business limit headroom by program
+		return getBusinessHeadroom(stub, args)
+	} else if function == "recordTDS" {
+		//Records the tax deducted by the business on an interest payment
+		return recordTDS(stub, args)
+	} else if function == "getTDSReceivable" {
+		//Returns the TDS deducted by the business in a financial year
+		return getTDSReceivable(stub, args)
+	} else if function == "addContraWallet" {
+		//Creates the contra wallet for an existing business
+		return addContraWallet(stub, args)
 	}
 	return shim.Error("No function named " + function + " in Businessssssss")
 }
 
 func putNewBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 11 {
+	if (len(args) != 11) && (len(args) != 12) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in putNewBusinessInfo (required:11) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in putNewBusinessInfo (required:11 or 12) given:" + xLenStr)
 
 	}
 
+	//GST state code -> args[11] (optional, set later through updateBusinessInfo)
+	stateCode := ""
+	if len(args) == 12 {
+		if !validStateCode(args[11]) {
+			return shim.Error("Invalid GST state code " + args[11])
+		}
+		stateCode = args[11]
+	}
+
 	response := busIDexists(stub, args[0])
 	if response.Status != shim.OK {
 		return shim.Error(response.Message)
@@ -138,12 +175,23 @@ func putNewBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Resp
 	BusinessInterestOutstandingWalletIDsha := hex.EncodeToString(md)
 	createWallet(stub, BusinessInterestOutstandingWalletIDsha, args[10])
 
-	newInfo := &businessInfo{args[1], args[2], businessLimitConv, BusinessWalletIDsha, BusinessLoanWalletIDsha, BusinessLiabilityWalletIDsha, maxROIconvertion, minROIconvertion, BusinessPrincipalOutstandingWalletIDsha, BusinessInterestOutstandingWalletIDsha}
+	// Hashing ContraWalletID
+	ContraWalletStr := args[2] + "BusinessContraWallet"
+	hash.Write([]byte(ContraWalletStr))
+	md = hash.Sum(nil)
+	ContraWalletIDsha := hex.EncodeToString(md)
+	createWallet(stub, ContraWalletIDsha, "0")
+
+	newInfo := &businessInfo{args[1], args[2], businessLimitConv, BusinessWalletIDsha, BusinessLoanWalletIDsha, BusinessLiabilityWalletIDsha, maxROIconvertion, minROIconvertion, BusinessPrincipalOutstandingWalletIDsha, BusinessInterestOutstandingWalletIDsha, "INR", 0, stateCode, ContraWalletIDsha}
 	newInfoBytes, _ := json.Marshal(newInfo)
 	err = stub.PutState(args[0], newInfoBytes) // businessID = args[0]
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -157,6 +205,23 @@ func createWallet(stub shim.ChaincodeStubInterface, walletID string, amt string)
 	return shim.Success([]byte("created new wallet from business"))
 }
 
+// Businesses written before minor units carry the limit in rupees and no currency
+func migrateLegacyBusiness(bus *businessInfo) {
+	if bus.Currency == "" {
+		bus.BusinessLimit = bus.BusinessLimit * 100
+		bus.Currency = "INR"
+	}
+}
+
+// validStateCode accepts the two digit state codes that begin a GSTIN
+func validStateCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	num, err := strconv.Atoi(code)
+	return err == nil && num > 0
+}
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
@@ -184,9 +249,9 @@ func getBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respons
 	if err != nil {
 		return shim.Error("Unable to parse businessInfo into the structure " + err.Error())
 	}
-	jsonString := fmt.Sprintf("%+v", parsedBusinessInfo)
-	fmt.Printf("Business Info: %s\n", jsonString)
-	return shim.Success(nil)
+	migrateLegacyBusiness(&parsedBusinessInfo)
+	parsedBusinessInfoBytes, _ := json.Marshal(parsedBusinessInfo)
+	return shim.Success(parsedBusinessInfoBytes)
 }
 
 func busIDexists(stub shim.ChaincodeStubInterface, busID string) pb.Response {
@@ -202,7 +267,7 @@ func updateBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Resp
 
 	/*
 		args[0] -> BusinessId
-		args[1] -> Business Limit / MAX ROI / MAX ROI
+		args[1] -> Business Limit / MAX ROI / MAX ROI / TDS rate / State code
 		args[2] -> values
 	*/
 	if len(args) != 3 {
@@ -222,20 +287,40 @@ func updateBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Resp
 	if err != nil {
 		return shim.Error("Unable to parse businessInfo into the structure(updateBusinessInfo) " + err.Error())
 	}
+	migrateLegacyBusiness(&parsedBusinessInfo)
 
 	lowerStr := strings.ToLower(args[1])
 
-	value, err := strconv.ParseInt(args[2], 10, 64)
-	if err != nil {
-		return shim.Error("value (updateBusinessInfo):" + err.Error())
-	}
-
-	if lowerStr == "business limit" {
-		parsedBusinessInfo.BusinessLimit = value
-	} else if lowerStr == "max roi" {
-		parsedBusinessInfo.MaxROI = value
-	} else if lowerStr == "min roi" {
-		parsedBusinessInfo.MinROI = value
+	//TDS rate is a percentage with decimals, the state code is text, the other fields are whole numbers
+	if lowerStr == "tds rate" {
+		rate, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return shim.Error("value (updateBusinessInfo):" + err.Error())
+		}
+		if rate < 0 || rate >= 100 {
+			return shim.Error("TDS rate " + args[2] + " is not between 0 and 100")
+		}
+		parsedBusinessInfo.TDSRate = rate
+	} else if lowerStr == "state code" {
+		if !validStateCode(args[2]) {
+			return shim.Error("Invalid GST state code " + args[2])
+		}
+		parsedBusinessInfo.StateCode = args[2]
+	} else {
+		value, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return shim.Error("value (updateBusinessInfo):" + err.Error())
+		}
+
+		if lowerStr == "business limit" {
+			parsedBusinessInfo.BusinessLimit = value
+		} else if lowerStr == "max roi" {
+			parsedBusinessInfo.MaxROI = value
+		} else if lowerStr == "min roi" {
+			parsedBusinessInfo.MinROI = value
+		} else {
+			return shim.Error("Invalid field for updateBusinessInfo: " + args[1])
+		}
 	}
 
 	parsedBusinessInfoBytes, _ := json.Marshal(parsedBusinessInfo)
@@ -243,6 +328,10 @@ func updateBusinessInfo(stub shim.ChaincodeStubInterface, args []string) pb.Resp
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 
 }
@@ -279,6 +368,8 @@ func getWalletID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		walletID = parsedBusinessInfo.BusinessPrincipalOutstandingWalletID
 	case "interestOut":
 		walletID = parsedBusinessInfo.BusinessInterestOutstandingWalletID
+	case "contra":
+		walletID = parsedBusinessInfo.ContraWalletID
 	default:
 		return shim.Error("There is no wallet of this type in Business :" + args[1])
 	}
@@ -286,6 +377,50 @@ func getWalletID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	return shim.Success([]byte(walletID))
 }
 
+func addContraWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> businessID
+		Businesses written before the memo legs were balanced have no contra wallet
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in addContraWallet (required:1) given:" + xLenStr)
+	}
+	businessBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error("Failed to get the business information: " + err.Error())
+	} else if businessBytes == nil {
+		return shim.Error("No information is avalilable on this businessID " + args[0])
+	}
+	business := businessInfo{}
+	err = json.Unmarshal(businessBytes, &business)
+	if err != nil {
+		return shim.Error("Unable to parse into the structure " + err.Error())
+	}
+	if business.ContraWalletID != "" {
+		return shim.Error("Business " + args[0] + " already has the contra wallet")
+	}
+
+	md := sha256.Sum256([]byte(business.BusinessAcNo + "BusinessContraWallet"))
+	business.ContraWalletID = hex.EncodeToString(md[:])
+	response := createWallet(stub, business.ContraWalletID, "0")
+	if response.Status != shim.OK {
+		return response
+	}
+
+	businessBytes, _ = json.Marshal(business)
+	err = stub.PutState(args[0], businessBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
 func main() {
 	err := shim.Start(new(chainCode))
 	if err != nil {
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type programHeadroom struct {
+	ProgramID   string
+	Sanctioned  int64
+	Disbursed   int64
+	Outstanding int64
+	Utilized    int64
+}
+
+type headroomReport struct {
+	BusinessID    string
+	Currency      string
+	BusinessLimit int64
+	Utilized      int64
+	Available     int64
+	Programs      []programHeadroom
+}
+
+func readBusiness(stub shim.ChaincodeStubInterface, businessID string) (businessInfo, error) {
+
+	bus := businessInfo{}
+	busBytes, err := stub.GetState(businessID)
+	if err != nil {
+		return bus, err
+	} else if busBytes == nil {
+		return bus, errors.New("No information is avalilable on this businessID " + businessID)
+	}
+	err = json.Unmarshal(busBytes, &bus)
+	if err != nil {
+		return bus, err
+	}
+	migrateLegacyBusiness(&bus)
+	return bus, nil
+}
+
+// readExposures returns the exposure of the business under every program it
+// has loans in, in minor units of the business currency. A loan counts against
+// its exposure business and, when it is a different business, against its buyer.
+func readExposures(stub shim.ChaincodeStubInterface, businessID string) ([]programHeadroom, int64, error) {
+
+	exposureIterator, err := stub.GetStateByPartialCompositeKey("Exposure~BusinessID~ProgramID", []string{businessID})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer exposureIterator.Close()
+
+	programs := []programHeadroom{}
+	var utilized int64
+	for exposureIterator.HasNext() {
+		exposureData, err := exposureIterator.Next()
+		if err != nil {
+			return nil, 0, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(exposureData.Key)
+		if err != nil {
+			return nil, 0, err
+		}
+		exposure := common.Utilization{}
+		err = json.Unmarshal(exposureData.Value, &exposure)
+		if err != nil {
+			return nil, 0, err
+		}
+		programs = append(programs, programHeadroom{keyParts[1], exposure.Sanctioned, exposure.Disbursed, exposure.Outstanding, exposure.Utilized()})
+		utilized += exposure.Utilized()
+	}
+	return programs, utilized, nil
+}
+
+func updateExposure(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> BusinessID
+		args[1] -> ProgramID
+		args[2] -> event (sanction, disbursement, repayment, write off or lapse)
+		args[3] -> amount in minor units
+		args[4] -> currency of the amount
+		args[5] -> LoanID
+	*/
+	if len(args) != 6 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in updateExposure(business) (required:6) given:" + xLenStr)
+	}
+
+	bus, err := readBusiness(stub, args[0])
+	if err != nil {
+		return shim.Error("updateExposure " + err.Error())
+	}
+
+	amt, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid amount (updateExposure):" + err.Error())
+	}
+	if amt <= 0 {
+		return shim.Error("Exposure amount must be greater than zero: " + args[3])
+	}
+
+	// Loans in another currency count against the limit at the rate of their sanction
+	loanKey, err := stub.CreateCompositeKey("Exposure~BusinessID~ProgramID~LoanID", []string{args[0], args[1], args[5]})
+	if err != nil {
+		return shim.Error("Unable to create composite key Exposure~BusinessID~ProgramID~LoanID:" + err.Error())
+	}
+	move, err := common.ApplyLoanEvent(stub, loanKey, args[2], amt, args[4], bus.Currency)
+	if err != nil {
+		return shim.Error("updateExposure " + err.Error())
+	}
+
+	exposureKey, err := stub.CreateCompositeKey("Exposure~BusinessID~ProgramID", []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error("Unable to create composite key Exposure~BusinessID~ProgramID:" + err.Error())
+	}
+	exposure := common.Utilization{}
+	exposureBytes, err := stub.GetState(exposureKey)
+	if err != nil {
+		return shim.Error("updateExposure " + err.Error())
+	}
+	if exposureBytes != nil {
+		err = json.Unmarshal(exposureBytes, &exposure)
+		if err != nil {
+			return shim.Error("Unable to parse the exposure (updateExposure):" + err.Error())
+		}
+	}
+
+	if args[2] == "sanction" {
+		// The limit is checked against the exposure across all programs
+		_, utilized, err := readExposures(stub, args[0])
+		if err != nil {
+			return shim.Error("updateExposure " + err.Error())
+		}
+		if utilized+move.Sanctioned > bus.BusinessLimit {
+			return shim.Error(fmt.Sprintf("Sanction of %d breaches the business limit %d of %s, available: %d", move.Sanctioned, bus.BusinessLimit, args[0], bus.BusinessLimit-utilized))
+		}
+	}
+	exposure.Add(move)
+
+	exposureBytes, _ = json.Marshal(exposure)
+	err = stub.PutState(exposureKey, exposureBytes)
+	if err != nil {
+		return shim.Error("Error in exposure updation " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getBusinessHeadroom(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getBusinessHeadroom(business) (required:1) given:" + xLenStr)
+	}
+
+	bus, err := readBusiness(stub, args[0])
+	if err != nil {
+		return shim.Error("getBusinessHeadroom " + err.Error())
+	}
+	programs, utilized, err := readExposures(stub, args[0])
+	if err != nil {
+		return shim.Error("getBusinessHeadroom " + err.Error())
+	}
+
+	report := headroomReport{args[0], bus.Currency, bus.BusinessLimit, utilized, bus.BusinessLimit - utilized, programs}
+	reportBytes, _ := json.Marshal(report)
+	return shim.Success(reportBytes)
+}
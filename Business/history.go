@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+func getHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> BusinessID
+		args[1] -> as of date (dd/mm/yyyy, optional) versions after that day are left out
+	*/
+	if (len(args) != 1) && (len(args) != 2) {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getHistory(business) (required:1 or 2) given:" + xLenStr)
+	}
+
+	asOf := ""
+	if len(args) == 2 {
+		asOf = args[1]
+	}
+	return common.GetHistory(stub, args[0], asOf)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// Tax deducted by the business on an interest payment, receivable by the bank
+// against the business's TDS certificate
+type tdsEntry struct {
+	TxnID    string
+	TxnType  string
+	LoanID   string
+	BankID   string
+	TxnDate  time.Time
+	Interest int64 //interest settled including the tax
+	TDS      int64
+	Rate     float64
+}
+
+type tdsReport struct {
+	BusinessID    string
+	FinancialYear string
+	Interest      int64
+	TDS           int64
+	Entries       []tdsEntry
+}
+
+func recordTDS(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> BusinessID (deductor)
+		args[1] -> TxnID
+		args[2] -> TxnType
+		args[3] -> LoanID
+		args[4] -> BankID
+		args[5] -> TxnDate (dd/mm/yyyy)
+		args[6] -> Interest settled including the tax
+		args[7] -> TDS
+		args[8] -> TDS rate
+	*/
+	if len(args) != 9 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in recordTDS(business) (required:9) given:" + xLenStr)
+	}
+
+	_, err := readBusiness(stub, args[0])
+	if err != nil {
+		return shim.Error("recordTDS " + err.Error())
+	}
+	txnDate, err := time.Parse("02/01/2006", args[5])
+	if err != nil {
+		return shim.Error("Invalid TxnDate in recordTDS:" + err.Error())
+	}
+	interest, err := strconv.ParseInt(args[6], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid interest in recordTDS:" + err.Error())
+	}
+	tds, err := strconv.ParseInt(args[7], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid TDS in recordTDS:" + err.Error())
+	}
+	if tds <= 0 || tds > interest {
+		return shim.Error("TDS " + args[7] + " must be positive and within the interest " + args[6])
+	}
+	rate, err := strconv.ParseFloat(args[8], 64)
+	if err != nil {
+		return shim.Error("Invalid TDS rate in recordTDS:" + err.Error())
+	}
+
+	tdsKey, err := stub.CreateCompositeKey("TDS~BusinessID~FinancialYear~TxnID", []string{args[0], common.FinancialYear(txnDate), args[1]})
+	if err != nil {
+		return shim.Error("Unable to create composite key TDS~BusinessID~FinancialYear~TxnID:" + err.Error())
+	}
+	ifExists, err := stub.GetState(tdsKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ifExists != nil {
+		return shim.Error("TDS of TxnID " + args[1] + " is already recorded")
+	}
+
+	entry := tdsEntry{args[1], args[2], args[3], args[4], txnDate, interest, tds, rate}
+	entryBytes, _ := json.Marshal(entry)
+	err = stub.PutState(tdsKey, entryBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getTDSReceivable(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> BusinessID
+		args[1] -> financial year (2025-26)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getTDSReceivable(business) (required:2) given:" + xLenStr)
+	}
+
+	tdsIterator, err := stub.GetStateByPartialCompositeKey("TDS~BusinessID~FinancialYear~TxnID", []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error("Unable to fetch the TDS entries:" + err.Error())
+	}
+	defer tdsIterator.Close()
+
+	report := tdsReport{args[0], args[1], 0, 0, []tdsEntry{}}
+	for tdsIterator.HasNext() {
+		tdsData, err := tdsIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the TDS entries:" + err.Error())
+		}
+		entry := tdsEntry{}
+		err = json.Unmarshal(tdsData.Value, &entry)
+		if err != nil {
+			return shim.Error("Unable to parse the TDS entry:" + err.Error())
+		}
+		report.Interest += entry.Interest
+		report.TDS += entry.TDS
+		report.Entries = append(report.Entries, entry)
+	}
+
+	reportBytes, _ := json.Marshal(report)
+	return shim.Success(reportBytes)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"putCalendar":     {Roles: []string{"platform admin"}},
+	"putHoliday":      {Roles: []string{"platform admin"}},
+	"removeHoliday":   {Roles: []string{"platform admin"}},
+	"isBusinessDay":   {Roles: []string{"*"}},
+	"adjustDate":      {Roles: []string{"*"}},
+	"getCalendarInfo": {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
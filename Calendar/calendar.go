@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type chainCode struct {
+}
+
+// A calendar belongs to a bank or a region. Dates that fall on a weekly off
+// or on a holiday of the calendar are not business days.
+type calendarInfo struct {
+	CalendarName string
+	WeeklyOffs   []string //weekday names, sunday by default
+	By           string
+}
+
+type holidayInfo struct {
+	CalendarID  string
+	HolidayDate time.Time
+	Description string
+	By          string
+}
+
+func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if function == "putCalendar" {
+		//Creates or updates a bank/region calendar
+		return putCalendar(stub, args)
+	} else if function == "putHoliday" {
+		//Adds a holiday to a calendar
+		return putHoliday(stub, args)
+	} else if function == "removeHoliday" {
+		//Removes a holiday from a calendar
+		return removeHoliday(stub, args)
+	} else if function == "isBusinessDay" {
+		//Returns true if the date is a business day in the calendar
+		return isBusinessDay(stub, args)
+	} else if function == "adjustDate" {
+		//Rolls a date to a business day using the given convention
+		return adjustDate(stub, args)
+	} else if function == "getCalendarInfo" {
+		//Returns the calendar, fails if it does not exist
+		return getCalendarInfo(stub, args)
+	}
+	return shim.Error("No function named " + function + " in Calendar")
+}
+
+func putCalendar(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> CalendarID (bank or region)
+		args[1] -> CalendarName
+		args[2] -> WeeklyOffs separated by ";" (eg. saturday;sunday)
+		args[3] -> By
+	*/
+	if len(args) != 4 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in putCalendar (required:4) given:" + xLenStr)
+	}
+
+	weekdays := map[string]bool{}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekdays[strings.ToLower(day.String())] = true
+	}
+
+	weeklyOffs := []string{}
+	for _, day := range strings.Split(args[2], ";") {
+		dayLower := strings.ToLower(strings.TrimSpace(day))
+		if dayLower == "" {
+			continue
+		}
+		if !weekdays[dayLower] {
+			return shim.Error("Invalid weekly off " + day)
+		}
+		weeklyOffs = append(weeklyOffs, dayLower)
+	}
+	if len(weeklyOffs) == 7 {
+		return shim.Error("Calendar " + args[0] + " has no business days")
+	}
+
+	calendarKey, err := stub.CreateCompositeKey("CalendarID", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to create composite key CalendarID:" + err.Error())
+	}
+
+	calendar := calendarInfo{args[1], weeklyOffs, args[3]}
+	calendarBytes, _ := json.Marshal(calendar)
+	err = stub.PutState(calendarKey, calendarBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func putHoliday(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> CalendarID
+		args[1] -> HolidayDate (dd/mm/yyyy)
+		args[2] -> Description
+		args[3] -> By
+	*/
+	if len(args) != 4 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in putHoliday (required:4) given:" + xLenStr)
+	}
+
+	_, err := getCalendar(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	hDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid holiday date (putHoliday):" + err.Error())
+	}
+
+	holidayKey, err := stub.CreateCompositeKey("CalendarID~HolidayDate", []string{args[0], hDate.Format("2006-01-02")})
+	if err != nil {
+		return shim.Error("Unable to create composite key CalendarID~HolidayDate:" + err.Error())
+	}
+
+	holiday := holidayInfo{args[0], hDate, args[2], args[3]}
+	holidayBytes, _ := json.Marshal(holiday)
+	err = stub.PutState(holidayKey, holidayBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func removeHoliday(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> CalendarID
+		args[1] -> HolidayDate (dd/mm/yyyy)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in removeHoliday (required:2) given:" + xLenStr)
+	}
+
+	hDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid holiday date (removeHoliday):" + err.Error())
+	}
+
+	holidayKey, err := stub.CreateCompositeKey("CalendarID~HolidayDate", []string{args[0], hDate.Format("2006-01-02")})
+	if err != nil {
+		return shim.Error("Unable to create composite key CalendarID~HolidayDate:" + err.Error())
+	}
+	ifExists, err := stub.GetState(holidayKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ifExists == nil {
+		return shim.Error("No holiday on " + args[1] + " in calendar " + args[0])
+	}
+
+	err = stub.DelState(holidayKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getCalendar(stub shim.ChaincodeStubInterface, calendarID string) (calendarInfo, error) {
+
+	calendar := calendarInfo{}
+	calendarKey, err := stub.CreateCompositeKey("CalendarID", []string{calendarID})
+	if err != nil {
+		return calendar, err
+	}
+	calendarBytes, err := stub.GetState(calendarKey)
+	if err != nil {
+		return calendar, err
+	} else if calendarBytes == nil {
+		return calendar, fmt.Errorf("No calendar exists on this CalendarID: %s", calendarID)
+	}
+	err = json.Unmarshal(calendarBytes, &calendar)
+	return calendar, err
+}
+
+func getCalendarInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getCalendarInfo (required:1) given:" + xLenStr)
+	}
+	calendar, err := getCalendar(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	calendarBytes, _ := json.Marshal(calendar)
+	return shim.Success(calendarBytes)
+}
+
+// businessDay reports whether the date is a business day. An empty calendarID
+// is the default calendar with Sunday as the only weekly off and no holidays.
+func businessDay(stub shim.ChaincodeStubInterface, calendarID string, date time.Time) (bool, error) {
+
+	if calendarID == "" {
+		return date.Weekday() != time.Sunday, nil
+	}
+
+	calendar, err := getCalendar(stub, calendarID)
+	if err != nil {
+		return false, err
+	}
+	weekday := strings.ToLower(date.Weekday().String())
+	for _, day := range calendar.WeeklyOffs {
+		if day == weekday {
+			return false, nil
+		}
+	}
+
+	holidayKey, err := stub.CreateCompositeKey("CalendarID~HolidayDate", []string{calendarID, date.Format("2006-01-02")})
+	if err != nil {
+		return false, err
+	}
+	holidayBytes, err := stub.GetState(holidayKey)
+	if err != nil {
+		return false, err
+	}
+	return holidayBytes == nil, nil
+}
+
+func isBusinessDay(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> CalendarID
+		args[1] -> date (dd/mm/yyyy)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in isBusinessDay (required:2) given:" + xLenStr)
+	}
+
+	date, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid date (isBusinessDay):" + err.Error())
+	}
+
+	ok, err := businessDay(stub, args[0], date)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.FormatBool(ok)))
+}
+
+func adjustDate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> CalendarID
+		args[1] -> date (dd/mm/yyyy)
+		args[2] -> roll convention (following, modified following or preceding)
+	*/
+	if len(args) == 1 {
+		args = strings.Split(args[0], ",")
+	}
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in adjustDate (required:3) given:" + xLenStr)
+	}
+
+	date, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid date (adjustDate):" + err.Error())
+	}
+
+	convention := strings.ToLower(args[2])
+	if convention == "" {
+		convention = "following"
+	}
+
+	var adjusted time.Time
+	switch convention {
+	case "following":
+		adjusted, err = rollDate(stub, args[0], date, 1)
+	case "preceding":
+		adjusted, err = rollDate(stub, args[0], date, -1)
+	case "modified following":
+		// Roll forward unless it crosses into the next month, then roll back
+		adjusted, err = rollDate(stub, args[0], date, 1)
+		if err == nil && adjusted.Month() != date.Month() {
+			adjusted, err = rollDate(stub, args[0], date, -1)
+		}
+	default:
+		return shim.Error("Invalid roll convention " + args[2])
+	}
+	if err != nil {
+		return shim.Error("adjustDate " + err.Error())
+	}
+
+	return shim.Success([]byte(adjusted.Format("02/01/2006")))
+}
+
+func rollDate(stub shim.ChaincodeStubInterface, calendarID string, date time.Time, step int) (time.Time, error) {
+
+	// A year of consecutive non business days means the calendar is misconfigured
+	for i := 0; i < 366; i++ {
+		ok, err := businessDay(stub, calendarID, date)
+		if err != nil {
+			return date, err
+		}
+		if ok {
+			return date, nil
+		}
+		date = date.AddDate(0, 0, step)
+	}
+	return date, fmt.Errorf("No business day within a year in calendar %s", calendarID)
+}
+
+func main() {
+	err := shim.Start(new(chainCode))
+	if err != nil {
+		fmt.Printf("Error starting Calendar chaincode: %s\n", err)
+	}
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/utils"
+)
+
+// Permission lets a function be called by an identity holding one of Roles
+// (the "role" attribute of its certificate, "*" for any identity on the
+// channel), or from a transaction that entered the network through one of
+// Chaincodes.
+type Permission struct {
+	Roles      []string
+	Chaincodes []string
+}
+
+// RoleMSPs ties every role to the organisations whose CA may issue it, a
+// "role" attribute on an identity of any other MSP is not honoured
+var RoleMSPs = map[string][]string{
+	"platform admin": {"Org1MSP"},
+	"bank maker":     {"Org1MSP"},
+	"bank checker":   {"Org1MSP"},
+	"seller":         {"Org2MSP"},
+	"anchor":         {"Org2MSP"},
+}
+
+// CheckAccess checks the caller against the permission of the function
+func CheckAccess(stub shim.ChaincodeStubInterface, function string, permissions map[string]Permission) error {
+
+	perm, ok := permissions[function]
+	if !ok {
+		return errors.New("No permission defined for " + function)
+	}
+
+	if len(perm.Chaincodes) != 0 {
+		entry, err := EntryChaincode(stub)
+		if err != nil {
+			return err
+		}
+		for _, ccName := range perm.Chaincodes {
+			if ccName == entry {
+				return nil
+			}
+		}
+	}
+	if len(perm.Roles) == 0 {
+		return errors.New(function + " can only be called through " + strings.Join(perm.Chaincodes, ", "))
+	}
+
+	for _, role := range perm.Roles {
+		if role == "*" {
+			return nil
+		}
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New("Unable to read the caller MSP ID " + err.Error())
+	}
+	role, found, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return errors.New("Unable to read the caller role " + err.Error())
+	} else if !found {
+		return errors.New("Identity from " + mspID + " has no role, cannot call " + function)
+	}
+	if !roleFromMSP(role, mspID) {
+		return errors.New("Role " + role + " cannot be held by an identity from " + mspID)
+	}
+	for _, allowed := range perm.Roles {
+		if allowed == role {
+			return nil
+		}
+	}
+	return errors.New("Role " + role + " from " + mspID + " is not permitted to call " + function)
+}
+
+// CallerName is the identity submitting the transaction, its MSP ID and the
+// common name of its certificate
+func CallerName(stub shim.ChaincodeStubInterface) (string, error) {
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", errors.New("Unable to read the caller MSP ID " + err.Error())
+	}
+	name, err := cid.GetID(stub)
+	if err != nil {
+		return "", errors.New("Unable to read the caller identity " + err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err == nil && cert != nil {
+		name = cert.Subject.CommonName
+	}
+	return mspID + "/" + name, nil
+}
+
+// CheckCallerBusiness lets seller and anchor identities act only for the
+// businesses named, through the "businessID" attribute of their certificate.
+// Bank and platform identities act for any business.
+func CheckCallerBusiness(stub shim.ChaincodeStubInterface, businessIDs ...string) error {
+
+	role, _, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return errors.New("Unable to read the caller role " + err.Error())
+	}
+	if role != "seller" && role != "anchor" {
+		return nil
+	}
+	businessID, found, err := cid.GetAttributeValue(stub, "businessID")
+	if err != nil {
+		return errors.New("Unable to read the caller business " + err.Error())
+	} else if !found {
+		return errors.New("Identity with role " + role + " has no businessID")
+	}
+	for _, allowed := range businessIDs {
+		if strings.EqualFold(allowed, businessID) {
+			return nil
+		}
+	}
+	return errors.New("Business " + businessID + " is not a party to this record")
+}
+
+func roleFromMSP(role string, mspID string) bool {
+	for _, allowed := range RoleMSPs[role] {
+		if allowed == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+// EntryChaincode returns the chaincode named in the client proposal. Chaincode
+// to chaincode calls share the proposal, so it is the chaincode the
+// transaction was submitted to.
+func EntryChaincode(stub shim.ChaincodeStubInterface) (string, error) {
+
+	signedProposal, err := stub.GetSignedProposal()
+	if err != nil {
+		return "", err
+	}
+	proposal, err := utils.GetProposal(signedProposal.ProposalBytes)
+	if err != nil {
+		return "", err
+	}
+	header, err := utils.GetHeader(proposal.Header)
+	if err != nil {
+		return "", err
+	}
+	extension, err := utils.GetChaincodeHeaderExtension(header)
+	if err != nil {
+		return "", err
+	}
+	return extension.ChaincodeId.Name, nil
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// AdjustDueDate rolls the due date to a business day of the program calendar
+func AdjustDueDate(stub shim.ChaincodeStubInterface, programID string, dueDate time.Time) (time.Time, error) {
+
+	// The program decides the holiday calendar and the roll convention
+	chaincodeArgs := ToChaincodeArgs("getRollConvention", programID)
+	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return dueDate, errors.New(response.Message)
+	}
+	rollInfo := strings.Split(string(response.Payload), ",")
+
+	chaincodeArgs = ToChaincodeArgs("adjustDate", rollInfo[0], dueDate.Format("02/01/2006"), rollInfo[1])
+	response = stub.InvokeChaincode("calendarcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return dueDate, errors.New(response.Message)
+	}
+	return time.Parse("02/01/2006", string(response.Payload))
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// FXRate returns the fxratecc rate from fromCurrency into toCurrency applicable
+// on rateDate, 1 when the currencies are the same
+func FXRate(stub shim.ChaincodeStubInterface, fromCurrency string, toCurrency string, rateDate time.Time) (float64, error) {
+
+	if strings.EqualFold(fromCurrency, toCurrency) {
+		return 1, nil
+	}
+	chaincodeArgs := ToChaincodeArgs("getFXRate", fromCurrency, toCurrency, rateDate.Format("02/01/2006"))
+	response := stub.InvokeChaincode("fxratecc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, errors.New("Unable to convert " + fromCurrency + " to " + toCurrency + ": " + response.Message)
+	}
+	rate, err := strconv.ParseFloat(string(response.Payload), 64)
+	if err != nil {
+		return 0, errors.New("Unable to parse the fx rate " + err.Error())
+	}
+	return rate, nil
+}
+
+// ConvertAmount converts amt minor units of fromCurrency into toCurrency at
+// the fxratecc rate applicable on rateDate
+func ConvertAmount(stub shim.ChaincodeStubInterface, amt int64, fromCurrency string, toCurrency string, rateDate time.Time) (int64, error) {
+
+	rate, err := FXRate(stub, fromCurrency, toCurrency, rateDate)
+	if err != nil {
+		return 0, err
+	}
+	return ConvertAtRate(amt, rate), nil
+}
+
+// ConvertAtRate converts amt minor units at a rate got from FXRate
+func ConvertAtRate(amt int64, rate float64) int64 {
+	return int64(math.Round(float64(amt) * rate))
+}
+
+// TxDate is the date of the transaction timestamp
+func TxDate(stub shim.ChaincodeStubInterface) (time.Time, error) {
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	txTime := time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC()
+	return txTime.Truncate(24 * time.Hour), nil
+}
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Submitter of a transaction, written once per transaction that changes a record
+type submitterInfo struct {
+	MSPID     string
+	Submitter string
+}
+
+// HistoryInfo is one version of a record with the identity that wrote it
+type HistoryInfo struct {
+	TxID      string
+	Timestamp time.Time
+	IsDelete  bool
+	MSPID     string
+	Submitter string
+	Value     json.RawMessage //the record as stored by that transaction
+}
+
+// RecordSubmitter stores the identity submitting the transaction for getHistory
+func RecordSubmitter(stub shim.ChaincodeStubInterface) error {
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return errors.New("Unable to read the submitter MSP ID " + err.Error())
+	}
+	submitter := ""
+	cert, err := cid.GetX509Certificate(stub)
+	if err == nil && cert != nil {
+		submitter = cert.Subject.CommonName
+	}
+
+	submitterKey, err := stub.CreateCompositeKey("TxID~Submitter", []string{stub.GetTxID()})
+	if err != nil {
+		return errors.New("Unable to create composite key TxID~Submitter " + err.Error())
+	}
+	submitterBytes, _ := json.Marshal(submitterInfo{mspID, submitter})
+	return stub.PutState(submitterKey, submitterBytes)
+}
+
+func keyHistory(stub shim.ChaincodeStubInterface, key string, asOfDate time.Time) ([]HistoryInfo, error) {
+
+	history := []HistoryInfo{}
+	historyIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return history, err
+	}
+	defer historyIterator.Close()
+
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return history, err
+		}
+
+		txTime := time.Unix(modification.Timestamp.GetSeconds(), int64(modification.Timestamp.GetNanos())).UTC()
+		if !asOfDate.IsZero() && txTime.After(asOfDate) {
+			continue
+		}
+
+		version := HistoryInfo{modification.TxId, txTime, modification.IsDelete, "", "", nil}
+		if !modification.IsDelete {
+			version.Value = json.RawMessage(modification.Value)
+		}
+
+		// Versions written before the audit trail have no submitter recorded
+		submitterKey, err := stub.CreateCompositeKey("TxID~Submitter", []string{modification.TxId})
+		if err != nil {
+			return history, err
+		}
+		submitterBytes, err := stub.GetState(submitterKey)
+		if err != nil {
+			return history, err
+		}
+		if submitterBytes != nil {
+			submitter := submitterInfo{}
+			err = json.Unmarshal(submitterBytes, &submitter)
+			if err == nil {
+				version.MSPID = submitter.MSPID
+				version.Submitter = submitter.Submitter
+			}
+		}
+		history = append(history, version)
+	}
+	return history, nil
+}
+
+// GetHistory returns every version of the record under key, asOf (dd/mm/yyyy,
+// empty for all) leaves out the versions written after that day
+func GetHistory(stub shim.ChaincodeStubInterface, key string, asOf string) pb.Response {
+
+	var asOfDate time.Time
+	if asOf != "" {
+		date, err := time.Parse("02/01/2006", asOf)
+		if err != nil {
+			return shim.Error("Invalid as of date in getHistory:" + err.Error())
+		}
+		asOfDate = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	history, err := keyHistory(stub, key, asOfDate)
+	if err != nil {
+		return shim.Error("Unable to fetch the history:" + err.Error())
+	}
+
+	historyBytes, _ := json.Marshal(history)
+	return shim.Success(historyBytes)
+}
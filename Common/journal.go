@@ -0,0 +1,153 @@
+// Package common holds the code shared by the chaincodes
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// JournalLeg is one movement of a journal applied by walletcc postJournal
+type JournalLeg struct {
+	WalletID string
+	Type     string //debit (decreasing), credit (increasing) or conversion
+	Amt      int64
+	Currency string //defaults to the wallet currency
+
+	//Memo legs move the tracking wallets (asset, loan, outstanding, liability
+	//and loan wallets) along with the journal, they balance among themselves
+	//and not against the main wallets
+	Memo bool
+
+	//Contra memo legs mirror the tracking wallets of the other side of the
+	//entry, a business outstanding against a bank asset or the contra wallet
+	//of a bank or business against one of its own trackers, and are counted
+	//on the other side of the memo balance
+	Contra bool
+
+	//Conversion legs carry no wallet, they move Amt of Currency into
+	//ConvertedAmt of ToCurrency at the fxratecc rate for RateDate
+	ToCurrency   string
+	ConvertedAmt int64
+	RateDate     string
+}
+
+// JournalResult is the movement of the wallet of a debit or credit leg
+type JournalResult struct {
+	WalletID   string
+	OpeningBal int64
+	CAmt       int64
+	DAmt       int64
+	TxnBal     int64
+}
+
+// TxnRow is the Txn_Bal_Ledger row written for a leg, the opening balance,
+// the credit, the debit and the balance come from the journal result
+type TxnRow struct {
+	TxnBalID string //has to be unique, carry the TxnID in it
+	TxnID    string
+	TxnDate  string //dd/mm/yyyy
+	LoanID   string
+	InsID    string
+	TxnType  string
+	Amt      string
+	By       string
+	Currency string //INR when empty
+}
+
+// Journal collects the legs of a transaction so that every wallet is read
+// and written once, in a single postJournal call
+type Journal struct {
+	legs []JournalLeg
+	rows []TxnRow
+}
+
+// ArgsRow is the row of a leg posted by a transaction chaincode from its
+// arguments (TxnID, TxnType, TxnDate, LoanID, InsID, Amt, ..., By)
+func ArgsRow(txnBalID string, args []string) TxnRow {
+	return TxnRow{txnBalID, args[0], args[2], args[3], args[4], args[1], args[5], args[8], ""}
+}
+
+// Add appends a leg and the row it is recorded under, a leg with no amount is left out
+func (j *Journal) Add(leg JournalLeg, row TxnRow) {
+	if leg.Amt == 0 {
+		return
+	}
+	j.legs = append(j.legs, leg)
+	if leg.Type != "conversion" {
+		j.rows = append(j.rows, row)
+	}
+}
+
+// Debit appends a leg decreasing the wallet
+func (j *Journal) Debit(walletID string, amt int64, memo bool, row TxnRow) {
+	j.Add(JournalLeg{WalletID: walletID, Type: "debit", Amt: amt, Memo: memo}, row)
+}
+
+// Credit appends a leg increasing the wallet
+func (j *Journal) Credit(walletID string, amt int64, memo bool, row TxnRow) {
+	j.Add(JournalLeg{WalletID: walletID, Type: "credit", Amt: amt, Memo: memo}, row)
+}
+
+// Contra appends a memo leg mirroring the trackers moved the same way on the other side
+func (j *Journal) Contra(walletID string, legType string, amt int64, row TxnRow) {
+	j.Add(JournalLeg{WalletID: walletID, Type: legType, Amt: amt, Memo: true, Contra: true}, row)
+}
+
+// Empty reports whether the journal has no legs to post
+func (j *Journal) Empty() bool {
+	return len(j.legs) == 0
+}
+
+// Post applies the legs through walletcc postJournal and writes the
+// Txn_Bal_Ledger row of every debit and credit leg
+func (j *Journal) Post(stub shim.ChaincodeStubInterface) ([]JournalResult, error) {
+
+	legsBytes, _ := json.Marshal(j.legs)
+	walletArgs := ToChaincodeArgs("postJournal", string(legsBytes))
+	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
+	if walletResponse.Status != shim.OK {
+		return nil, errors.New(walletResponse.Message)
+	}
+
+	results := []JournalResult{}
+	err := json.Unmarshal(walletResponse.Payload, &results)
+	if err != nil {
+		return nil, errors.New("Unable to parse the journal results " + err.Error())
+	}
+	if len(results) != len(j.rows) {
+		return nil, errors.New("Journal returned " + strconv.Itoa(len(results)) + " results for " + strconv.Itoa(len(j.rows)) + " legs")
+	}
+
+	// generate txn_balance_object for every leg and write it to the Txn_Bal_Ledger.
+	// txnbalcc reads the statement position of a wallet as it was before the
+	// transaction, so the legs of a wallet are numbered here in leg order
+	walletLegs := map[string]int64{}
+	for i, result := range results {
+		row := j.rows[i]
+		currency := row.Currency
+		if currency == "" {
+			currency = "INR"
+		}
+		argsList := []string{row.TxnBalID, row.TxnID, row.TxnDate, row.LoanID, row.InsID, result.WalletID, strconv.FormatInt(result.OpeningBal, 10), row.TxnType, row.Amt, strconv.FormatInt(result.CAmt, 10), strconv.FormatInt(result.DAmt, 10), strconv.FormatInt(result.TxnBal, 10), row.By, currency, strconv.FormatInt(walletLegs[result.WalletID], 10)}
+		walletLegs[result.WalletID]++
+		chaincodeArgs := ToChaincodeArgs("putTxnInfo", strings.Join(argsList, ","))
+		response := stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return nil, errors.New(response.Message)
+		}
+	}
+	return results, nil
+}
+
+// ToChaincodeArgs converts the arguments of an InvokeChaincode call
+func ToChaincodeArgs(args ...string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i, arg := range args {
+		bargs[i] = []byte(arg)
+	}
+	return bargs
+}
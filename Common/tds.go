@@ -0,0 +1,75 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TDSDeducted returns the tax the payer withheld on the interest it paid in
+// cash and the rate it comes to. args[10], when given, is the tax deducted,
+// otherwise the business TDS rate is applied to the cash interest, which is
+// the interest net of the tax. The tax cannot settle more than interestLeft.
+func TDSDeducted(stub shim.ChaincodeStubInterface, args []string, interestPaid int64, interestLeft int64) (int64, float64, error) {
+
+	if len(args) == 11 {
+		tds, err := strconv.ParseInt(args[10], 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("TDS " + err.Error())
+		}
+		if tds < 0 || tds > interestLeft {
+			return 0, 0, errors.New("TDS " + args[10] + " is not between zero and the interest due after the payment " + strconv.FormatInt(interestLeft, 10))
+		}
+		if tds == 0 {
+			return 0, 0, nil
+		}
+		return tds, float64(tds) * 100 / float64(interestPaid+tds), nil
+	}
+
+	chaincodeArgs := ToChaincodeArgs("getBusinessInfo", args[6])
+	response := stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, 0, errors.New(response.Message)
+	}
+	business := struct {
+		TDSRate float64
+	}{}
+	err := json.Unmarshal(response.Payload, &business)
+	if err != nil {
+		return 0, 0, errors.New("unable to parse the business " + err.Error())
+	}
+	if business.TDSRate <= 0 || interestPaid <= 0 {
+		return 0, 0, nil
+	}
+
+	tds := int64(math.Round(float64(interestPaid) * business.TDSRate / (100 - business.TDSRate)))
+	if tds > interestLeft {
+		tds = interestLeft
+	}
+	return tds, business.TDSRate, nil
+}
+
+// RecordTDS adds the tax to the TDS register of the payer in businesscc
+func RecordTDS(stub shim.ChaincodeStubInterface, args []string, interest int64, tds int64, rate float64) error {
+
+	chaincodeArgs := ToChaincodeArgs("recordTDS", args[6], args[0], args[1], args[3], args[7], args[2], strconv.FormatInt(interest, 10), strconv.FormatInt(tds, 10), strconv.FormatFloat(rate, 'f', -1, 64))
+	response := stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+	return nil
+}
+
+// FinancialYear returns the April to March year of the date as 2025-26
+func FinancialYear(date time.Time) string {
+	year := date.Year()
+	if date.Month() < time.April {
+		year--
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Utilization of a limit in minor units of the limit currency. A sanction
+// uses the limit until it is disbursed or lapses, the disbursed amount uses
+// it until it is repaid or written off.
+type Utilization struct {
+	Sanctioned  int64
+	Disbursed   int64
+	Outstanding int64
+}
+
+// Utilized is the part of the limit in use
+func (u Utilization) Utilized() int64 {
+	return u.Sanctioned - u.Disbursed + u.Outstanding
+}
+
+// Apply moves the utilization by an event of a loan, checking a sanction
+// against the limit is left to the caller
+func (u *Utilization) Apply(event string, amt int64) error {
+
+	switch event {
+	case "sanction":
+		u.Sanctioned += amt
+	case "disbursement":
+		u.Disbursed += amt
+		u.Outstanding += amt
+	case "repayment", "write off":
+		u.Outstanding -= amt
+		if u.Outstanding < 0 {
+			u.Outstanding = 0
+		}
+	case "lapse":
+		u.Sanctioned -= amt
+		if u.Sanctioned < u.Disbursed {
+			u.Sanctioned = u.Disbursed
+		}
+	default:
+		return errors.New("Invalid utilization event " + event)
+	}
+	return nil
+}
+
+// Add moves the utilization by the move a loan event made in it
+func (u *Utilization) Add(move Utilization) {
+	u.Sanctioned += move.Sanctioned
+	u.Disbursed += move.Disbursed
+	u.Outstanding += move.Outstanding
+	if u.Outstanding < 0 {
+		u.Outstanding = 0
+	}
+	if u.Sanctioned < u.Disbursed {
+		u.Sanctioned = u.Disbursed
+	}
+}
+
+// LoanUtilization is the utilization of a limit by one loan, kept in the loan
+// currency along with the rate the sanction was converted into the limit
+// currency at, so that the loan releases at that rate what it took
+type LoanUtilization struct {
+	Rate float64
+	Loan Utilization
+}
+
+// limitUtilization is the loan utilization in the limit currency
+func (l LoanUtilization) limitUtilization() Utilization {
+	return Utilization{ConvertAtRate(l.Loan.Sanctioned, l.Rate), ConvertAtRate(l.Loan.Disbursed, l.Rate), ConvertAtRate(l.Loan.Outstanding, l.Rate)}
+}
+
+// ApplyLoanEvent moves the utilization of the loan kept under loanKey by an
+// event of amt minor units of the loan currency and returns the move it makes
+// in the limit. The sanction fixes the rate at the transaction date; loans
+// sanctioned before loan utilizations were kept move the limit at the rate of
+// the transaction date.
+func ApplyLoanEvent(stub shim.ChaincodeStubInterface, loanKey string, event string, amt int64, currency string, limitCurrency string) (Utilization, error) {
+
+	loanUtilization := LoanUtilization{}
+	loanUtilizationBytes, err := stub.GetState(loanKey)
+	if err != nil {
+		return Utilization{}, err
+	}
+	if loanUtilizationBytes != nil {
+		err = json.Unmarshal(loanUtilizationBytes, &loanUtilization)
+		if err != nil {
+			return Utilization{}, errors.New("Unable to parse the loan utilization " + err.Error())
+		}
+	} else {
+		txDate, err := TxDate(stub)
+		if err != nil {
+			return Utilization{}, err
+		}
+		loanUtilization.Rate, err = FXRate(stub, currency, limitCurrency, txDate)
+		if err != nil {
+			return Utilization{}, err
+		}
+		if event != "sanction" {
+			return eventMove(event, ConvertAtRate(amt, loanUtilization.Rate))
+		}
+	}
+
+	before := loanUtilization.limitUtilization()
+	err = loanUtilization.Loan.Apply(event, amt)
+	if err != nil {
+		return Utilization{}, err
+	}
+	after := loanUtilization.limitUtilization()
+
+	loanUtilizationBytes, _ = json.Marshal(loanUtilization)
+	err = stub.PutState(loanKey, loanUtilizationBytes)
+	if err != nil {
+		return Utilization{}, err
+	}
+	return Utilization{after.Sanctioned - before.Sanctioned, after.Disbursed - before.Disbursed, after.Outstanding - before.Outstanding}, nil
+}
+
+// eventMove is the move an event of amt makes in a utilization
+func eventMove(event string, amt int64) (Utilization, error) {
+
+	switch event {
+	case "sanction":
+		return Utilization{Sanctioned: amt}, nil
+	case "disbursement":
+		return Utilization{Disbursed: amt, Outstanding: amt}, nil
+	case "repayment", "write off":
+		return Utilization{Outstanding: -amt}, nil
+	case "lapse":
+		return Utilization{Sanctioned: -amt}, nil
+	}
+	return Utilization{}, errors.New("Invalid utilization event " + event)
+}
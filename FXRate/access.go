@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"putFXRate": {Roles: []string{"platform admin", "bank maker"}},
+	"getFXRate": {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
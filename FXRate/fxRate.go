@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type chainCode struct {
+}
+
+type fxRateInfo struct {
+	FromCurrency string
+	ToCurrency   string
+	RateDate     time.Time
+	Rate         float64 //units of ToCurrency for one unit of FromCurrency
+	By           string
+}
+
+func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if function == "putFXRate" {
+		//Stores the rate of a currency pair for a date
+		return putFXRate(stub, args)
+	} else if function == "getFXRate" {
+		//Returns the latest rate of a currency pair on or before a date
+		return getFXRate(stub, args)
+	}
+	return shim.Error("No function named " + function + " in FXRate")
+}
+
+func putFXRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> FromCurrency
+		args[1] -> ToCurrency
+		args[2] -> RateDate (dd/mm/yyyy)
+		args[3] -> Rate
+		args[4] -> By
+	*/
+	if len(args) != 5 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in putFXRate (required:5) given:" + xLenStr)
+	}
+
+	fromCurrency := strings.ToUpper(args[0])
+	toCurrency := strings.ToUpper(args[1])
+	if (len(fromCurrency) != 3) || (len(toCurrency) != 3) || (fromCurrency == toCurrency) {
+		return shim.Error("Invalid currency pair " + args[0] + "/" + args[1])
+	}
+
+	rateDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Invalid rate date (putFXRate):" + err.Error())
+	}
+
+	rate, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return shim.Error("Invalid rate (putFXRate):" + err.Error())
+	}
+	if rate <= 0 {
+		return shim.Error("Rate must be greater than zero: " + args[3])
+	}
+
+	rateKey, err := stub.CreateCompositeKey("FromCurrency~ToCurrency~RateDate", []string{fromCurrency, toCurrency, rateDate.Format("2006-01-02")})
+	if err != nil {
+		return shim.Error("Unable to create composite key FromCurrency~ToCurrency~RateDate:" + err.Error())
+	}
+
+	fxRate := fxRateInfo{fromCurrency, toCurrency, rateDate, rate, args[4]}
+	fxRateBytes, _ := json.Marshal(fxRate)
+	err = stub.PutState(rateKey, fxRateBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getFXRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> FromCurrency
+		args[1] -> ToCurrency
+		args[2] -> as of date (dd/mm/yyyy)
+	*/
+	if len(args) == 1 {
+		args = strings.Split(args[0], ",")
+	}
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getFXRate (required:3) given:" + xLenStr)
+	}
+
+	fromCurrency := strings.ToUpper(args[0])
+	toCurrency := strings.ToUpper(args[1])
+	if fromCurrency == toCurrency {
+		return shim.Success([]byte("1"))
+	}
+
+	asOfDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Invalid as of date (getFXRate):" + err.Error())
+	}
+
+	ratesIterator, err := stub.GetStateByPartialCompositeKey("FromCurrency~ToCurrency~RateDate", []string{fromCurrency, toCurrency})
+	if err != nil {
+		return shim.Error("Unable to fetch the rates (getFXRate):" + err.Error())
+	}
+	defer ratesIterator.Close()
+
+	// Keys are ordered by date, so the last one on or before the as of date is the applicable rate
+	fxRate := fxRateInfo{}
+	found := false
+	for ratesIterator.HasNext() {
+		rateData, err := ratesIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the rates (getFXRate):" + err.Error())
+		}
+		rate := fxRateInfo{}
+		err = json.Unmarshal(rateData.Value, &rate)
+		if err != nil {
+			return shim.Error("Unable to parse the rate (getFXRate):" + err.Error())
+		}
+		if rate.RateDate.After(asOfDate) {
+			break
+		}
+		fxRate = rate
+		found = true
+	}
+	if !found {
+		return shim.Error("No " + fromCurrency + "/" + toCurrency + " rate on or before " + args[2])
+	}
+
+	return shim.Success([]byte(strconv.FormatFloat(fxRate.Rate, 'f', -1, 64)))
+}
+
+func main() {
+	err := shim.Start(new(chainCode))
+	if err != nil {
+		fmt.Printf("Error starting FXRate chaincode: %s\n", err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"enterInstrument":      {Roles: []string{"anchor", "seller", "bank maker"}},
+	"getInstrument":        {Roles: []string{"*"}},
+	"updateInsStatus":      {Chaincodes: []string{"loancc", "approvalcc", "txncc", "disbursementcc", "repaycc", "marginrefundcc", "interestrefundcc", "piccc"}},
+	"getSellerIDnAmt":      {Roles: []string{"*"}},
+	"getHistory":           {Roles: []string{"*"}},
+	"enterInstrumentBatch": {Roles: []string{"anchor", "seller", "bank maker"}},
+	"getBatch":             {Roles: []string{"*"}},
+	"rollbackBatch":        {Roles: []string{"anchor", "seller", "bank maker"}},
+	"cancelInstrument":     {Roles: []string{"anchor", "seller", "bank maker"}},
+	"amendInstrument":      {Roles: []string{"anchor", "seller", "bank maker"}},
+	"raiseDispute":         {Roles: []string{"anchor", "seller", "bank maker"}},
+	"resolveDispute":       {Roles: []string{"bank maker", "bank checker"}},
+	"checkFinanceable":     {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type batchRow struct {
+	Row             int
+	InstrumentRefNo string
+	SellBusinessID  string
+	Status          string //accepted, rejected or rolled back
+	Reason          string
+}
+
+type batchInfo struct {
+	UploadBatchNo  string
+	Status         string //uploaded or rolled back
+	UploadTime     time.Time
+	Accepted       int
+	Rejected       int
+	Rows           []batchRow
+	RollbackReason string
+}
+
+func batchKey(stub shim.ChaincodeStubInterface, batchNo string) (string, error) {
+	return stub.CreateCompositeKey("Batch~UploadBatchNo", []string{batchNo})
+}
+
+func readBatch(stub shim.ChaincodeStubInterface, batchNo string) (string, batchInfo, error) {
+
+	batch := batchInfo{}
+	key, err := batchKey(stub, batchNo)
+	if err != nil {
+		return key, batch, err
+	}
+	batchBytes, err := stub.GetState(key)
+	if err != nil {
+		return key, batch, err
+	} else if batchBytes == nil {
+		return key, batch, errors.New("No batch exists on this UploadBatchNo: " + batchNo)
+	}
+	err = json.Unmarshal(batchBytes, &batch)
+	return key, batch, err
+}
+
+func enterInstrumentBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> UploadBatchNo
+		args[1] -> JSON array of instruments, each one the arguments of enterInstrument.
+				   The UploadBatchNo of every row is set to args[0]
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in enterInstrumentBatch (required:2) given:" + xLenStr)
+	}
+
+	key, err := batchKey(stub, args[0])
+	if err != nil {
+		return shim.Error("Unable to create composite key Batch~UploadBatchNo:" + err.Error())
+	}
+	ifExists, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ifExists != nil {
+		return shim.Error("UploadBatchNo " + args[0] + " exists. Cannot upload it again")
+	}
+
+	rows := [][]string{}
+	err = json.Unmarshal([]byte(args[1]), &rows)
+	if err != nil {
+		return shim.Error("Unable to parse the instruments (enterInstrumentBatch):" + err.Error())
+	}
+	if len(rows) == 0 {
+		return shim.Error("Batch " + args[0] + " has no instruments")
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batch := batchInfo{args[0], "uploaded", time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC(), 0, 0, []batchRow{}, ""}
+
+	// Writes of this transaction are not visible to GetState, so duplicates
+	// inside the batch are caught here
+	seen := map[string]bool{}
+	for i, row := range rows {
+		result := batchRow{Row: i + 1, Status: "rejected"}
+		if (len(row) != 10) && (len(row) != 11) {
+			result.Reason = "Invalid number of fields (required:10 or 11) given:" + strconv.Itoa(len(row))
+		} else {
+			result.InstrumentRefNo = row[0]
+			result.SellBusinessID = row[2]
+			row[8] = args[0]
+			instKey := instrumentKey(row[0], row[2])
+			if seen[instKey] {
+				result.Reason = "Instrument Reference No. – Supplier ID pair repeats in the batch"
+			} else if err := putInstrument(stub, row); err != nil {
+				result.Reason = err.Error()
+			} else {
+				result.Status = "accepted"
+				seen[instKey] = true
+			}
+		}
+		if result.Status == "accepted" {
+			batch.Accepted++
+		} else {
+			batch.Rejected++
+		}
+		batch.Rows = append(batch.Rows, result)
+	}
+
+	batchBytes, _ := json.Marshal(batch)
+	err = stub.PutState(key, batchBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(batchBytes)
+}
+
+func getBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getBatch (required:1) given:" + xLenStr)
+	}
+
+	_, batch, err := readBatch(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchBytes, _ := json.Marshal(batch)
+	return shim.Success(batchBytes)
+}
+
+func rollbackBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> UploadBatchNo
+		args[1] -> Reason
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in rollbackBatch (required:2) given:" + xLenStr)
+	}
+
+	key, batch, err := readBatch(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if batch.Status != "uploaded" {
+		return shim.Error("Batch " + args[0] + " is already " + batch.Status)
+	}
+
+	// A batch is rolled back as a whole, only while none of its instruments is financed
+	for _, row := range batch.Rows {
+		if row.Status != "accepted" {
+			continue
+		}
+		insBytes, err := stub.GetState(instrumentKey(row.InstrumentRefNo, row.SellBusinessID))
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if insBytes == nil {
+			return shim.Error("Instrument " + row.InstrumentRefNo + " of row " + strconv.Itoa(row.Row) + " no longer exists")
+		}
+		ins, err := parseInstrument(insBytes)
+		if err != nil {
+			return shim.Error("Error in unmarshaling the instrument (rollbackBatch)")
+		}
+		if ins.InsStatus != "open" {
+			return shim.Error("Instrument " + row.InstrumentRefNo + " of row " + strconv.Itoa(row.Row) + " is " + ins.InsStatus + ", batch cannot be rolled back")
+		}
+	}
+
+	for i, row := range batch.Rows {
+		if row.Status != "accepted" {
+			continue
+		}
+		err = stub.DelState(instrumentKey(row.InstrumentRefNo, row.SellBusinessID))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		batch.Rows[i].Status = "rolled back"
+	}
+	batch.Status = "rolled back"
+	batch.RollbackReason = args[1]
+
+	batchBytes, _ := json.Marshal(batch)
+	err = stub.PutState(key, batchBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
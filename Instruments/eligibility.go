@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func eligibilityError(rule string, format string, a ...interface{}) error {
+	return errors.New("Eligibility rule " + rule + " failed: " + fmt.Sprintf(format, a...))
+}
+
+// checkEligibility applies the program and PPR rules to an instrument at entry
+func checkEligibility(stub shim.ChaincodeStubInterface, args []string, instDate time.Time, dueDate time.Time, vDate time.Time) error {
+
+	/*
+		args[2] -> SellBusinessID
+		args[3] -> BuyBusinsessID
+		args[6] -> ProgramID
+		args[7] -> PPRid
+	*/
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	entryDate := time.Unix(txTimestamp.GetSeconds(), 0).UTC().Truncate(24 * time.Hour)
+
+	// ProgramStartDate,ProgramEndDate,DiscountPeriod,MaxExtensionDays,MinTenorPercent
+	chaincodeArgs := toChaincodeArgs("getProgramTerms", args[6])
+	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+	programTerms := strings.Split(string(response.Payload), ",")
+	pStartDate, err := time.Parse("02/01/2006", programTerms[0])
+	if err != nil {
+		return err
+	}
+	pEndDate, err := time.Parse("02/01/2006", programTerms[1])
+	if err != nil {
+		return err
+	}
+	discountPeriod, err := strconv.Atoi(programTerms[2])
+	if err != nil {
+		return err
+	}
+	minTenorPercent, err := strconv.Atoi(programTerms[4])
+	if err != nil {
+		return err
+	}
+
+	if entryDate.Before(pStartDate) || entryDate.After(pEndDate) {
+		return eligibilityError("program validity", "program %s runs from %s to %s", args[6], programTerms[0], programTerms[1])
+	}
+
+	// ProgramID,BusinessID,DiscountPeriod,StaleDays
+	chaincodeArgs = toChaincodeArgs("getPPRTerms", args[7])
+	response = stub.InvokeChaincode("pprcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+	pprTerms := strings.Split(string(response.Payload), ",")
+	if pprTerms[0] != args[6] {
+		return eligibilityError("ppr program", "PPR %s belongs to program %s, not %s", args[7], pprTerms[0], args[6])
+	}
+	if pprTerms[1] != args[2] && pprTerms[1] != args[3] {
+		return eligibilityError("ppr business", "PPR %s is of business %s, neither the seller nor the buyer", args[7], pprTerms[1])
+	}
+	pprDiscountPeriod, err := strconv.Atoi(pprTerms[2])
+	if err != nil {
+		return err
+	}
+	staleDays, err := strconv.Atoi(pprTerms[3])
+	if err != nil {
+		return err
+	}
+
+	if instDate.After(entryDate) {
+		return eligibilityError("instrument date", "instrument date %s is in the future", instDate.Format("02/01/2006"))
+	}
+	age := int(entryDate.Sub(instDate).Hours() / 24)
+	if staleDays > 0 && age > staleDays {
+		return eligibilityError("stale days", "instrument is %d days old, PPR %s allows %d", age, args[7], staleDays)
+	}
+
+	valueDate := vDate.Truncate(24 * time.Hour)
+	if !dueDate.After(valueDate) {
+		return eligibilityError("due date", "due date %s is not after the value date %s", dueDate.Format("02/01/2006"), valueDate.Format("02/01/2006"))
+	}
+
+	// The PPR discount period overrides the program's
+	if pprDiscountPeriod > 0 {
+		discountPeriod = pprDiscountPeriod
+	}
+	// An instrument has to run for at least the program's share of the discount
+	// period from its value date, the discount period itself is the longest tenor
+	if discountPeriod > 0 {
+		tenor := int(dueDate.Sub(valueDate).Hours() / 24)
+		minTenor := discountPeriod * minTenorPercent / 100
+		if tenor > discountPeriod {
+			return eligibilityError("maximum tenor", "tenor of %d days exceeds the discount period of %d days", tenor, discountPeriod)
+		}
+		if tenor < minTenor {
+			return eligibilityError("minimum tenor", "tenor of %d days is below the minimum of %d days", tenor, minTenor)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+func getHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+		args[2] -> as of date (dd/mm/yyyy, optional) versions after that day are left out
+	*/
+	if (len(args) != 2) && (len(args) != 3) {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getHistory(instrument) (required:2 or 3) given:" + xLenStr)
+	}
+
+	asOf := ""
+	if len(args) == 3 {
+		asOf = args[2]
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(strings.ToLower(args[0] + args[1])))
+	key := hex.EncodeToString(hash.Sum(nil))
+	return common.GetHistory(stub, key, asOf)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -18,24 +20,33 @@ type chainCode struct {
 
 type instrumentInfo struct {
 	//Instrument ID for storing is auto generated
-	InstrumentRefNo string    //[0]
-	InstrumenDate   time.Time //[1]
-	SellBusinessID  string    //[2]
-	BuyBusinsessID  string    //[3]
-	InsAmount       string    //[4]// use int64 for convertion
-	InsStatus       string    // not required
-	InsDueDate      time.Time //[5]
-	ProgramID       string    //[6]
-	PPRid           string    //[7]
-	UploadBatchNo   string    //[8]
-	ValueDate       time.Time //[9]
+	InstrumentRefNo    string    //[0]
+	InstrumenDate      time.Time //[1]
+	SellBusinessID     string    //[2]
+	BuyBusinsessID     string    //[3]
+	InsAmount          int64     //[4]//minor units
+	InsStatus          string    // not required
+	InsDueDate         time.Time //[5]
+	ProgramID          string    //[6]
+	PPRid              string    //[7]
+	UploadBatchNo      string    //[8]
+	ValueDate          time.Time //[9]
+	Currency           string    //[10]
+	ContractualDueDate time.Time //InsDueDate before the business day roll
+	Disputed           bool      //set by raiseDispute, blocks sanction and disbursement
+	Events             []instrumentEvent
+}
+
+// Instruments written before minor units carry InsAmount as a rupee string and no currency
+type legacyInstrumentInfo struct {
+	InsAmount string
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	indexName := "InstrumentRefNo~SellBusinessID~InsAmount"
 	inst := instrumentInfo{}
 
-	refNoSellIDkey, err := stub.CreateCompositeKey(indexName, []string{inst.InstrumentRefNo, inst.SellBusinessID, inst.InsAmount})
+	refNoSellIDkey, err := stub.CreateCompositeKey(indexName, []string{inst.InstrumentRefNo, inst.SellBusinessID, strconv.FormatInt(inst.InsAmount, 10)})
 	if err != nil {
 		return shim.Error("Composite key InstrumentRefNo~SellBusinessID~InsAmount can not be created (instrument)")
 	}
@@ -46,6 +57,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	if function == "enterInstrument" {
 		//Used to enter new instrument data
 		return enterInstrument(stub, args)
@@ -55,6 +71,36 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	} else if function == "updateInsStatus" {
 		//Updates instrument status accordingly
 		return updateInsStatus(stub, args)
+	} else if function == "getSellerIDnAmt" {
+		//Returns the seller, amount and currency of the instrument
+		return getSellerIDnAmt(stub, args)
+	} else if function == "getHistory" {
+		//Returns every past version of the record with its submitter
+		return getHistory(stub, args)
+	} else if function == "enterInstrumentBatch" {
+		//Enters an upload batch of instruments, each row is accepted or rejected on its own
+		return enterInstrumentBatch(stub, args)
+	} else if function == "getBatch" {
+		//Returns the batch with the result of every row
+		return getBatch(stub, args)
+	} else if function == "rollbackBatch" {
+		//Removes the instruments accepted in a batch
+		return rollbackBatch(stub, args)
+	} else if function == "cancelInstrument" {
+		//Cancels an open instrument
+		return cancelInstrument(stub, args)
+	} else if function == "amendInstrument" {
+		//Corrects the amount or the due date of an open instrument
+		return amendInstrument(stub, args)
+	} else if function == "raiseDispute" {
+		//Marks the instrument as disputed
+		return raiseDispute(stub, args)
+	} else if function == "resolveDispute" {
+		//Clears the dispute on the instrument
+		return resolveDispute(stub, args)
+	} else if function == "checkFinanceable" {
+		//Returns an error if the instrument is disputed or cancelled
+		return checkFinanceable(stub, args)
 	}
 
 	return shim.Error("No function named " + function + " in Instrumentsssss")
@@ -62,91 +108,126 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 }
 
 func enterInstrument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 10 {
+	if (len(args) != 10) && (len(args) != 11) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in enterInstrument (required:10) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in enterInstrument (required:10 or 11) given:" + xLenStr)
+
+	}
 
+	err := putInstrument(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// Instruments are stored under the hash of the reference number and the seller
+func instrumentKey(refNo string, sellerID string) string {
+	hash := sha256.New()
+	hash.Write([]byte(strings.ToLower(refNo + sellerID)))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// putInstrument validates one instrument and writes it, nothing is written when it is rejected
+func putInstrument(stub shim.ChaincodeStubInterface, args []string) error {
 
 	// Checking existence of Instrument Reference No. – Supplier ID pair
-	refNoSellIDiterator, _ := stub.GetStateByPartialCompositeKey("InstrumentRefNo~SellBusinessID~InsAmount", []string{args[0], args[2]})
-	refNoSellIDdata, _ := refNoSellIDiterator.Next()
-	if refNoSellIDdata != nil {
-		return shim.Error("Instrument Reference No. – Supplier ID pair already exists")
+	instIDsha := instrumentKey(args[0], args[2])
+	ifExists, err := stub.GetState(instIDsha)
+	if err != nil {
+		return err
+	} else if ifExists != nil {
+		return errors.New("Instrument Reference No. – Supplier ID pair already exists")
 	}
-	defer refNoSellIDiterator.Close()
 
 	//Checking existence of ProgramID
-	chaincodeArgs := toChaincodeArgs("programIDexists", args[7])
+	chaincodeArgs := toChaincodeArgs("programIDexists", args[6])
 	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
 	if response.Status == shim.OK {
-		return shim.Error("ProgramId " + args[1] + " does not exits")
+		return errors.New("ProgramId " + args[6] + " does not exits")
 	}
 
 	//Checking existence of pprID
-	chaincodeArgs = toChaincodeArgs("pprIDexists", args[8])
+	chaincodeArgs = toChaincodeArgs("pprIDexists", args[7])
 	response = stub.InvokeChaincode("pprcc", chaincodeArgs, "myc")
 	if response.Status == shim.OK {
-		return shim.Error("PprId " + args[8] + " does not exits")
+		return errors.New("PprId " + args[7] + " does not exits")
 	}
 
 	//Checking existence of SellerBusinessID
 	chaincodeArgs = toChaincodeArgs("busIDexists", args[2])
 	response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
 	if response.Status == shim.OK {
-		return shim.Error("BusinessId " + args[2] + " does not exits")
+		return errors.New("BusinessId " + args[2] + " does not exits")
 	}
 
 	//Checking existence of BuyerBusinessID
 	chaincodeArgs = toChaincodeArgs("busIDexists", args[3])
 	response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
 	if response.Status == shim.OK {
-		return shim.Error("BusinessId " + args[3] + " does not exits")
+		return errors.New("BusinessId " + args[3] + " does not exits")
 	}
 
 	//InstrumentDate -> instDate
 	instDate, err := time.Parse("02/01/2006", args[1])
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
-	_, err = strconv.ParseInt(args[4], 10, 64)
+	//InsAmount -> insAmt (minor units)
+	insAmt, err := strconv.ParseInt(args[4], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
 	//InsDueDate -> insDate
 	insDueDate, err := time.Parse("02/01/2006", args[5])
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	if insDueDate.Weekday().String() == "Sunday" {
-		fmt.Println("Since the due date falls on sunday, due date is extended to Monday(instrument) : ", insDueDate.AddDate(0, 0, 1))
+	//Rolling the due date to a business day, the contractual date is kept alongside
+	insContractualDueDate := insDueDate
+	insDueDate, err = common.AdjustDueDate(stub, args[6], insContractualDueDate)
+	if err != nil {
+		return errors.New("Unable to adjust the due date (instrument)" + err.Error())
 	}
-	insDueDate = insDueDate.AddDate(0, 0, 1)
 	//Converting the incoming date from Dd/mm/yy:hh:mm:ss to Dd/mm/yyThh:mm:ss for parsing
+	if len(args[9]) != 19 {
+		return errors.New("Invalid value date (instrument) " + args[9])
+	}
 	vString := args[9][:10] + "T" + args[9][11:] //removing the ":" part from the string
 
 	//ValueDate -> vDate
 	vDate, err := time.Parse("02/01/2006T15:04:05", vString)
 	if err != nil {
-		return shim.Error("error in parsing the date and time (instrument)" + err.Error())
+		return errors.New("error in parsing the date and time (instrument)" + err.Error())
 	}
 
-	// Hashing for key to store in ledger
-	hash := sha256.New()
-	instID := strings.ToLower(args[0] + args[2])
-	hash.Write([]byte(instID))
-	md := hash.Sum(nil)
-	instIDsha := hex.EncodeToString(md)
+	//Instrument must be eligible under the program and the PPR
+	err = checkEligibility(stub, args, instDate, insContractualDueDate, vDate)
+	if err != nil {
+		return err
+	}
 
-	inst := instrumentInfo{args[0], instDate, args[2], args[3], args[4], "open", insDueDate, args[6], args[7], args[8], vDate}
+	//Currency -> args[10] (optional, INR by default)
+	currency := "INR"
+	if len(args) == 11 {
+		currency = strings.ToUpper(args[10])
+		if len(currency) != 3 {
+			return errors.New("Invalid instrument currency " + args[10])
+		}
+	}
+
+	inst := instrumentInfo{args[0], instDate, args[2], args[3], insAmt, "open", insDueDate, args[6], args[7], args[8], vDate, currency, insContractualDueDate, false, []instrumentEvent{}}
 	instBytes, err := json.Marshal(inst)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	stub.PutState(instIDsha, instBytes)
-	return shim.Success(nil)
+	return stub.PutState(instIDsha, instBytes)
 }
 
 func toChaincodeArgs(args ...string) [][]byte {
@@ -165,33 +246,70 @@ func updateInsStatus(stub shim.ChaincodeStubInterface, args []string) pb.Respons
 		args[1] -> seller ID
 		args[2] -> status
 	*/
-	key := strings.ToLower(args[0] + args[1])
+	hash := sha256.New()
+	hash.Write([]byte(strings.ToLower(args[0] + args[1])))
+	key := hex.EncodeToString(hash.Sum(nil))
 	instBytes, err := stub.GetState(key)
 	if err != nil {
 		return shim.Error("Unable to fetch instrument info for status updation")
+	} else if instBytes == nil {
+		return shim.Error("No data exists on this InstrumentID (updateInsStatus): " + args[0])
 	}
-	inst := instrumentInfo{}
-	err = json.Unmarshal(instBytes, &inst)
+	inst, err := parseInstrument(instBytes)
 	if err != nil {
 		return shim.Error("Error in unmarshaling the instrument (updateInsStatus)")
 	}
 	/*
-	 updated sequentially Open > Sanctioned > Overdue>Settled or Open > Sanctioned > Settled
+	 updated sequentially Open > Sanctioned > (Disbursed) > Overdue > Settled or Open > Sanctioned > (Disbursed) > Settled
 	*/
-	if (args[2] == "sanctioned") && (inst.InsStatus != "open") {
+	if (args[2] == "sanctioned") && inst.Disputed {
+		return shim.Error("Instrument status cannot be sanctioned as it is under dispute")
+	} else if (args[2] == "sanctioned") && (inst.InsStatus != "open") {
 		return shim.Error("Instrument status cannot be sanctioned as it is not open")
-	} else if (args[2] == "overdue") && (inst.InsStatus != "sanctioned") {
-		return shim.Error("Instrument status cannot be overdue as it is not sanctioned")
-	} else if (args[2] == "settled") && ((inst.InsStatus != "overdue") || (inst.InsStatus != "sanctioned")) {
+	} else if (args[2] == "overdue") && (inst.InsStatus != "sanctioned") && (inst.InsStatus != "disbursed") {
+		return shim.Error("Instrument status cannot be overdue as it is not sanctioned or disbursed")
+	} else if (args[2] == "settled") && (inst.InsStatus != "overdue") && (inst.InsStatus != "sanctioned") && (inst.InsStatus != "disbursed") {
 		return shim.Error("Instrument status cannot be settled as it is not overdue or sanctioned")
 	}
 	inst.InsStatus = args[2]
 	instBytes, _ = json.Marshal(inst)
-	stub.PutState(key, instBytes)
+	err = stub.PutState(key, instBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success([]byte("Instrument status updated successfully"))
 
 }
 
+func parseInstrument(instBytes []byte) (instrumentInfo, error) {
+
+	inst := instrumentInfo{}
+	err := json.Unmarshal(instBytes, &inst)
+	if inst.Currency != "" {
+		return inst, err
+	}
+	if _, ok := err.(*json.UnmarshalTypeError); err != nil && !ok {
+		return inst, err
+	}
+
+	legacy := legacyInstrumentInfo{}
+	err = json.Unmarshal(instBytes, &legacy)
+	if err != nil {
+		return inst, err
+	}
+	insAmt, err := strconv.ParseInt(legacy.InsAmount, 10, 64)
+	if err != nil {
+		return inst, err
+	}
+	inst.InsAmount = insAmt * 100
+	inst.Currency = "INR"
+	return inst, nil
+}
+
 func getInstrument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 2 {
 		xLenStr := strconv.Itoa(len(args))
@@ -215,12 +333,42 @@ func getInstrument(stub shim.ChaincodeStubInterface, args []string) pb.Response
 		return shim.Error("No data exists on this InstrumentID: " + args[0])
 	}
 
-	ins := instrumentInfo{}
-	err = json.Unmarshal(insBytes, &ins)
+	ins, err := parseInstrument(insBytes)
+	if err != nil {
+		return shim.Error("Error in unmarshaling the instrument (getInstrument)")
+	}
 	insString := fmt.Sprintf("%+v", ins)
 	return shim.Success([]byte(insString))
 }
 
+func getSellerIDnAmt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getSellerIDnAmt (required:2) given:" + xLenStr)
+	}
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+	*/
+	hash := sha256.New()
+	hash.Write([]byte(strings.ToLower(args[0] + args[1])))
+	instIDsha := hex.EncodeToString(hash.Sum(nil))
+
+	insBytes, err := stub.GetState(instIDsha)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if insBytes == nil {
+		return shim.Error("No data exists on this InstrumentID (getSellerIDnAmt): " + args[0])
+	}
+
+	ins, err := parseInstrument(insBytes)
+	if err != nil {
+		return shim.Error("Error in unmarshaling the instrument (getSellerIDnAmt)")
+	}
+	insString := ins.SellBusinessID + "," + strconv.FormatInt(ins.InsAmount, 10) + "," + ins.Currency
+	return shim.Success([]byte(insString))
+}
+
 func main() {
 	err := shim.Start(new(chainCode))
 	if err != nil {
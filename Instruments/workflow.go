@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// Cancellations, amendments and disputes kept on the instrument
+type instrumentEvent struct {
+	Action  string //cancelled, amended, disputed or resolved
+	Reason  string
+	By      string //MSP ID/common name of the caller
+	TxID    string
+	Time    time.Time
+	Details string //field, old and new value of an amendment
+}
+
+func readInstrument(stub shim.ChaincodeStubInterface, refNo string, sellerID string) (string, instrumentInfo, error) {
+
+	key := instrumentKey(refNo, sellerID)
+	instBytes, err := stub.GetState(key)
+	if err != nil {
+		return key, instrumentInfo{}, err
+	} else if instBytes == nil {
+		return key, instrumentInfo{}, errors.New("No data exists on this InstrumentID: " + refNo)
+	}
+	inst, err := parseInstrument(instBytes)
+	if err != nil {
+		return key, inst, errors.New("Error in unmarshaling the instrument " + err.Error())
+	}
+	return key, inst, nil
+}
+
+// instrumentActor returns the caller recorded on an event, a seller or anchor
+// identity has to belong to one of the businesses given
+func instrumentActor(stub shim.ChaincodeStubInterface, businessIDs ...string) (string, error) {
+
+	err := common.CheckCallerBusiness(stub, businessIDs...)
+	if err != nil {
+		return "", err
+	}
+	return common.CallerName(stub)
+}
+
+// putInstrumentEvent appends the event to the instrument and writes it
+func putInstrumentEvent(stub shim.ChaincodeStubInterface, key string, inst instrumentInfo, action string, reason string, by string, details string) error {
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+	txTime := time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC()
+	inst.Events = append(inst.Events, instrumentEvent{action, reason, by, stub.GetTxID(), txTime, details})
+
+	instBytes, _ := json.Marshal(inst)
+	err = stub.PutState(key, instBytes)
+	if err != nil {
+		return err
+	}
+	return common.RecordSubmitter(stub)
+}
+
+func cancelInstrument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+		args[2] -> Reason
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in cancelInstrument (required:3) given:" + xLenStr)
+	}
+
+	key, inst, err := readInstrument(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("cancelInstrument " + err.Error())
+	}
+	by, err := instrumentActor(stub, inst.SellBusinessID)
+	if err != nil {
+		return shim.Error("cancelInstrument " + err.Error())
+	}
+	if inst.InsStatus != "open" {
+		return shim.Error("Instrument " + args[0] + " cannot be cancelled as it is " + inst.InsStatus)
+	}
+
+	inst.InsStatus = "cancelled"
+	err = putInstrumentEvent(stub, key, inst, "cancelled", args[2], by, "")
+	if err != nil {
+		return shim.Error("cancelInstrument " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func amendInstrument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+		args[2] -> field (amount or due date)
+		args[3] -> value (minor units or dd/mm/yyyy)
+		args[4] -> Reason
+	*/
+	if len(args) != 5 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in amendInstrument (required:5) given:" + xLenStr)
+	}
+
+	key, inst, err := readInstrument(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("amendInstrument " + err.Error())
+	}
+	by, err := instrumentActor(stub, inst.SellBusinessID)
+	if err != nil {
+		return shim.Error("amendInstrument " + err.Error())
+	}
+	if inst.InsStatus != "open" {
+		return shim.Error("Instrument " + args[0] + " cannot be amended as it is " + inst.InsStatus)
+	}
+	if inst.Disputed {
+		return shim.Error("Instrument " + args[0] + " cannot be amended while it is under dispute")
+	}
+
+	details := ""
+	lowerStr := strings.ToLower(args[2])
+	if lowerStr == "amount" {
+		insAmt, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return shim.Error("amendInstrument amount " + err.Error())
+		}
+		if insAmt <= 0 {
+			return shim.Error("Invalid instrument amount: " + args[3])
+		}
+		details = "amount " + strconv.FormatInt(inst.InsAmount, 10) + " to " + args[3]
+		inst.InsAmount = insAmt
+	} else if lowerStr == "due date" {
+		contractualDueDate, err := time.Parse("02/01/2006", args[3])
+		if err != nil {
+			return shim.Error("amendInstrument due date " + err.Error())
+		}
+
+		// The amended due date has to keep the instrument eligible
+		eligibilityArgs := []string{inst.InstrumentRefNo, "", inst.SellBusinessID, inst.BuyBusinsessID, "", "", inst.ProgramID, inst.PPRid}
+		err = checkEligibility(stub, eligibilityArgs, inst.InstrumenDate, contractualDueDate, inst.ValueDate)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		insDueDate, err := common.AdjustDueDate(stub, inst.ProgramID, contractualDueDate)
+		if err != nil {
+			return shim.Error("Unable to adjust the due date (amendInstrument)" + err.Error())
+		}
+		details = "due date " + inst.ContractualDueDate.Format("02/01/2006") + " to " + args[3]
+		inst.ContractualDueDate = contractualDueDate
+		inst.InsDueDate = insDueDate
+	} else {
+		return shim.Error("Invalid field for amendInstrument: " + args[2])
+	}
+
+	err = putInstrumentEvent(stub, key, inst, "amended", args[4], by, details)
+	if err != nil {
+		return shim.Error("amendInstrument " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func raiseDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+		args[2] -> Reason
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in raiseDispute (required:3) given:" + xLenStr)
+	}
+
+	key, inst, err := readInstrument(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("raiseDispute " + err.Error())
+	}
+	// The buyer disputes the invoice, the seller may flag it too
+	by, err := instrumentActor(stub, inst.SellBusinessID, inst.BuyBusinsessID)
+	if err != nil {
+		return shim.Error("raiseDispute " + err.Error())
+	}
+	if (inst.InsStatus == "cancelled") || (inst.InsStatus == "settled") {
+		return shim.Error("Instrument " + args[0] + " cannot be disputed as it is " + inst.InsStatus)
+	}
+	if inst.Disputed {
+		return shim.Error("Instrument " + args[0] + " is already under dispute")
+	}
+
+	inst.Disputed = true
+	err = putInstrumentEvent(stub, key, inst, "disputed", args[2], by, "")
+	if err != nil {
+		return shim.Error("raiseDispute " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func resolveDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+		args[2] -> Reason
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in resolveDispute (required:3) given:" + xLenStr)
+	}
+
+	key, inst, err := readInstrument(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("resolveDispute " + err.Error())
+	}
+	by, err := instrumentActor(stub)
+	if err != nil {
+		return shim.Error("resolveDispute " + err.Error())
+	}
+	if !inst.Disputed {
+		return shim.Error("Instrument " + args[0] + " is not under dispute")
+	}
+
+	inst.Disputed = false
+	err = putInstrumentEvent(stub, key, inst, "resolved", args[2], by, "")
+	if err != nil {
+		return shim.Error("resolveDispute " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func checkFinanceable(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> InstrumentRefNo
+		args[1] -> SellBusinessID
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in checkFinanceable (required:2) given:" + xLenStr)
+	}
+
+	_, inst, err := readInstrument(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("checkFinanceable " + err.Error())
+	}
+	if inst.Disputed {
+		return shim.Error("Instrument " + args[0] + " is under dispute")
+	}
+	if inst.InsStatus == "cancelled" {
+		return shim.Error("Instrument " + args[0] + " is cancelled")
+	}
+	return shim.Success(nil)
+}
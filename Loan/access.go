@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"newLoanInfo":         {Chaincodes: []string{"approvalcc"}},
+	"getLoanInfo":         {Roles: []string{"*"}},
+	"updateLoanInfo":      {Chaincodes: []string{"txncc", "disbursementcc", "repaycc", "marginrefundcc", "interestrefundcc", "piccc"}},
+	"loanIDexists":        {Roles: []string{"*"}},
+	"loanStatusSancAmt":   {Roles: []string{"*"}},
+	"getWalletID":         {Roles: []string{"*"}},
+	"getSellerID":         {Roles: []string{"*"}},
+	"accrueInterest":      {Roles: []string{"platform admin"}},
+	"getAccruedAfter":     {Roles: []string{"*"}},
+	"markOverdue":         {Roles: []string{"platform admin"}},
+	"queryLoans":          {Roles: []string{"*"}},
+	"reindexLoans":        {Roles: []string{"platform admin"}},
+	"getHistory":          {Roles: []string{"*"}},
+	"extendLoan":          {Chaincodes: []string{"approvalcc"}},
+	"classifyLoans":       {Roles: []string{"platform admin"}},
+	"setAssetClassConfig": {Roles: []string{"platform admin"}},
+	"getAssetClassConfig": {Roles: []string{"*"}},
+	"writeOffLoan":        {Chaincodes: []string{"txncc"}},
+	"recoverWriteOff":     {Chaincodes: []string{"txncc"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
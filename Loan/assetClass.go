@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// Days past due at which each class begins, and the provision held against
+// the outstanding principal of a loan in the class in percent
+type assetClassConfig struct {
+	SMA0Days       int64
+	SMA1Days       int64
+	SMA2Days       int64
+	NPADays        int64
+	ProvisionRates map[string]float64
+}
+
+var assetClasses = []string{"standard", "sma-0", "sma-1", "sma-2", "npa"}
+
+var defaultAssetClassConfig = assetClassConfig{1, 31, 61, 91, map[string]float64{
+	"standard": 0.4,
+	"sma-0":    0.4,
+	"sma-1":    0.4,
+	"sma-2":    0.4,
+	"npa":      15,
+}}
+
+type loanClassification struct {
+	LoanID          string
+	PreviousClass   string
+	AssetClass      string
+	DPD             int64
+	Provision       int64
+	ProvisionChange int64 //credited (debited when negative) to the bank provision wallet
+}
+
+type classificationSummary struct {
+	AsOfDate   time.Time
+	Classified []loanClassification
+	Skipped    []skippedLoan
+}
+
+func (config assetClassConfig) classOf(dpd int64) string {
+	switch {
+	case dpd >= config.NPADays:
+		return "npa"
+	case dpd >= config.SMA2Days:
+		return "sma-2"
+	case dpd >= config.SMA1Days:
+		return "sma-1"
+	case dpd >= config.SMA0Days:
+		return "sma-0"
+	}
+	return "standard"
+}
+
+func readAssetClassConfig(stub shim.ChaincodeStubInterface) (assetClassConfig, error) {
+
+	configKey, err := stub.CreateCompositeKey("Config~Name", []string{"assetClass"})
+	if err != nil {
+		return defaultAssetClassConfig, errors.New("Unable to create composite key Config~Name:" + err.Error())
+	}
+	configBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return defaultAssetClassConfig, err
+	} else if configBytes == nil {
+		return defaultAssetClassConfig, nil
+	}
+	config := assetClassConfig{}
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return defaultAssetClassConfig, errors.New("Unable to parse the asset class config " + err.Error())
+	}
+	return config, nil
+}
+
+func setAssetClassConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> days past due starting SMA-0,SMA-1,SMA-2,NPA (eg 1,31,61,91)
+		args[1] -> provision percent for standard,sma-0,sma-1,sma-2,npa (eg 0.4,0.4,0.4,0.4,15)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in setAssetClassConfig(loan) (required:2) given:" + xLenStr)
+	}
+
+	daysList := strings.Split(args[0], ",")
+	if len(daysList) != 4 {
+		return shim.Error("Days past due thresholds must be given for SMA-0,SMA-1,SMA-2,NPA: " + args[0])
+	}
+	days := make([]int64, 4)
+	for i, dayStr := range daysList {
+		day, err := strconv.ParseInt(strings.TrimSpace(dayStr), 10, 64)
+		if err != nil {
+			return shim.Error("Invalid days past due threshold " + dayStr)
+		}
+		if day <= 0 || (i > 0 && day <= days[i-1]) {
+			return shim.Error("Days past due thresholds must be positive and increasing: " + args[0])
+		}
+		days[i] = day
+	}
+
+	ratesList := strings.Split(args[1], ",")
+	if len(ratesList) != len(assetClasses) {
+		return shim.Error("Provision rates must be given for " + strings.Join(assetClasses, ",") + ": " + args[1])
+	}
+	rates := map[string]float64{}
+	for i, rateStr := range ratesList {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		if err != nil {
+			return shim.Error("Invalid provision rate " + rateStr)
+		}
+		if rate < 0 || rate > 100 {
+			return shim.Error("Provision rate " + rateStr + " is not between 0 and 100")
+		}
+		rates[assetClasses[i]] = rate
+	}
+
+	config := assetClassConfig{days[0], days[1], days[2], days[3], rates}
+	configKey, err := stub.CreateCompositeKey("Config~Name", []string{"assetClass"})
+	if err != nil {
+		return shim.Error("Unable to create composite key Config~Name:" + err.Error())
+	}
+	configBytes, _ := json.Marshal(config)
+	err = stub.PutState(configKey, configBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getAssetClassConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	config, err := readAssetClassConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	configBytes, _ := json.Marshal(config)
+	return shim.Success(configBytes)
+}
+
+func classifyLoans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> as of date (dd/mm/yyyy)
+		args[1] -> BankID holding the provisions
+		Every loan with principal outstanding is classified by its days past
+		due and the provision for its class is topped up or released in the
+		bank provision wallet. Collected loans release what they still hold.
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in classifyLoans(loan) (required:2) given:" + xLenStr)
+	}
+
+	asOfDate, err := time.Parse("02/01/2006", args[0])
+	if err != nil {
+		return shim.Error("Invalid as of date in classifyLoans(loan):" + err.Error())
+	}
+
+	config, err := readAssetClassConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	chaincodeArgs := toChaincodeArgs("getWalletID", args[1], "provision")
+	response := stub.InvokeChaincode("bankcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+	provisionWalletID := string(response.Payload)
+	if provisionWalletID == "" {
+		return shim.Error("Bank " + args[1] + " has no provision wallet, run addProvisionWallets")
+	}
+	chaincodeArgs = toChaincodeArgs("getWalletID", args[1], "contra")
+	response = stub.InvokeChaincode("bankcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+	contraWalletID := string(response.Payload)
+	if contraWalletID == "" {
+		return shim.Error("Bank " + args[1] + " has no contra wallet, run addContraWallet")
+	}
+
+	loansIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the loans (classifyLoans):" + err.Error())
+	}
+	defer loansIterator.Close()
+
+	outstandingStatus := map[string]bool{
+		"part disbursed": true,
+		"disbursed":      true,
+		"overdue":        true,
+		"part collected": true,
+	}
+
+	summary := classificationSummary{asOfDate, []loanClassification{}, []skippedLoan{}}
+	// The loans share the bank provision wallet, the run posts their net change to it once
+	var provisionChange int64
+	for loansIterator.HasNext() {
+		loanData, err := loansIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the loans (classifyLoans):" + err.Error())
+		}
+
+		loan := loanInfo{}
+		err = json.Unmarshal(loanData.Value, &loan)
+		if err != nil || loan.InstNum == "" {
+			continue
+		}
+		migrateLegacyLoan(&loan)
+		if !outstandingStatus[loan.LoanStatus] && loan.Provision == 0 {
+			continue
+		}
+		// A loan is classified once a day, the Txn_Bal_Ledger key carries the date
+		if !asOfDate.After(loan.ClassDate) {
+			continue
+		}
+
+		principal := int64(0)
+		dpd := int64(0)
+		if outstandingStatus[loan.LoanStatus] {
+			principal, err = getWalletValue(stub, loan.LoanDisbursedWalletID)
+			if err != nil {
+				summary.Skipped = append(summary.Skipped, skippedLoan{loanData.Key, err.Error()})
+				continue
+			}
+			if asOfDate.After(loan.DueDate) {
+				dpd = int64(asOfDate.Sub(loan.DueDate).Hours() / 24)
+			}
+		}
+
+		previousClass := loan.AssetClass
+		assetClass := config.classOf(dpd)
+		provision := int64(0)
+		if principal > 0 {
+			provision = int64(math.Round(float64(principal) * config.ProvisionRates[assetClass] / 100))
+		}
+		change := provision - loan.Provision
+
+		provisionChange += change
+
+		loan.AssetClass = assetClass
+		loan.DPD = dpd
+		loan.ClassDate = asOfDate
+		loan.Provision = provision
+		loanBytes, _ := json.Marshal(loan)
+		err = stub.PutState(loanData.Key, loanBytes)
+		if err != nil {
+			return shim.Error("Error in loan classification (classifyLoans) " + err.Error())
+		}
+		if previousClass != assetClass || change != 0 {
+			summary.Classified = append(summary.Classified, loanClassification{loanData.Key, previousClass, assetClass, dpd, provision, change})
+		}
+	}
+
+	if provisionChange != 0 {
+		amt := provisionChange
+		if amt < 0 {
+			amt = -amt
+		}
+		row := common.TxnRow{
+			TxnBalID: "1PRV" + args[1] + stub.GetTxID(),
+			TxnID:    stub.GetTxID(),
+			TxnDate:  asOfDate.Format("02/01/2006"),
+			TxnType:  "provision",
+			Amt:      strconv.FormatInt(amt, 10),
+			By:       "system",
+		}
+		legType := "credit"
+		if provisionChange < 0 {
+			legType = "debit"
+		}
+		//The bank contra wallet mirrors the provision wallet
+		journal := common.Journal{}
+		journal.Add(common.JournalLeg{WalletID: provisionWalletID, Type: legType, Amt: amt, Memo: true}, row)
+		contraRow := row
+		contraRow.TxnBalID = "2PRV" + args[1] + stub.GetTxID()
+		journal.Contra(contraWalletID, legType, amt, contraRow)
+		_, err = journal.Post(stub)
+		if err != nil {
+			return shim.Error("Bank Provision Wallet(classifyLoans) " + err.Error())
+		}
+	}
+
+	if len(summary.Classified) != 0 {
+		err = common.RecordSubmitter(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	summaryBytes, _ := json.Marshal(summary)
+	return shim.Success(summaryBytes)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// Every change of the due date after sanction, oldest first
+type dueDateRevision struct {
+	PreviousDueDate    time.Time //ContractualDueDate before the revision
+	ContractualDueDate time.Time
+	DueDate            time.Time //ContractualDueDate rolled to a business day
+	Fee                int64     //extension fee charged to LoanChargesWalletID
+	Reason             string
+	By                 string
+	TxID               string
+	Time               time.Time
+}
+
+func extendLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> LoanID
+		args[1] -> new due date (dd/mm/yyyy)
+		args[2] -> extension fee in minor units (0 for none)
+		args[3] -> Reason
+		args[4] -> By
+	*/
+	if len(args) != 5 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in extendLoan(loan) (required:5) given:" + xLenStr)
+	}
+
+	loanBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if loanBytes == nil {
+		return shim.Error("No data exists on this loanID: " + args[0])
+	}
+	loan := loanInfo{}
+	err = json.Unmarshal(loanBytes, &loan)
+	if err != nil {
+		return shim.Error("error in unmarshiling loan: in extendLoan" + err.Error())
+	}
+	migrateLegacyLoan(&loan)
+
+	extendable := map[string]bool{
+		"sanctioned":     true,
+		"part disbursed": true,
+		"disbursed":      true,
+		"overdue":        true,
+	}
+	if !extendable[loan.LoanStatus] {
+		return shim.Error("Loan " + args[0] + " cannot be extended as it is " + loan.LoanStatus)
+	}
+
+	// Loans written before contractual due dates keep the rolled date as the contractual one
+	if loan.ContractualDueDate.IsZero() {
+		loan.ContractualDueDate = loan.DueDate
+	}
+	if loan.OriginalDueDate.IsZero() {
+		loan.OriginalDueDate = loan.ContractualDueDate
+	}
+
+	newDueDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid due date in extendLoan:" + err.Error())
+	}
+	if !newDueDate.After(loan.ContractualDueDate) {
+		return shim.Error("New due date " + args[1] + " is not after the current due date " + loan.ContractualDueDate.Format("02/01/2006"))
+	}
+
+	fee, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid extension fee in extendLoan:" + err.Error())
+	}
+	if fee < 0 {
+		return shim.Error("Extension fee cannot be negative: " + args[2])
+	}
+
+	err = checkExtension(stub, loan, newDueDate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dDate, err := common.AdjustDueDate(stub, loan.ProgramID, newDueDate)
+	if err != nil {
+		return shim.Error("Unable to adjust the due date(extendLoan):" + err.Error())
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime := time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC()
+
+	revision := dueDateRevision{loan.ContractualDueDate, newDueDate, dDate, fee, args[3], args[4], stub.GetTxID(), txTime}
+	loan.DueDateRevisions = append(loan.DueDateRevisions, revision)
+	loan.ContractualDueDate = newDueDate
+	loan.DueDate = dDate
+
+	// An extension past today cures the overdue
+	previousStatus := loan.LoanStatus
+	if loan.LoanStatus == "overdue" && dDate.After(txTime) {
+		loan.LoanStatus = "disbursed"
+	}
+
+	// The fee and the accrual both credit the Business Interest O/s Wallet, so they are posted as one journal
+	journal := common.Journal{}
+
+	//Extension fee is charged to the loan and to the business charges/interest O/s
+	if fee > 0 {
+		keySuffix := "EXT" + strconv.Itoa(len(loan.DueDateRevisions))
+		chaincodeArgs := toChaincodeArgs("getWalletID", loan.ExposureBusinessID, "interestOut")
+		response := stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error(response.Message)
+		}
+		journal.Credit(loan.LoanChargesWalletID, fee, true, loanRow(stub, "1"+keySuffix, args[0], loan, txTime, "charges", fee, "system"))
+		journal.Contra(string(response.Payload), "credit", fee, loanRow(stub, "2"+keySuffix, args[0], loan, txTime, "charges", fee, "system"))
+	}
+
+	// Days between the old and the new due date now earn interest, those already past are accrued here
+	if loan.LoanStatus != "sanctioned" {
+		_, err = accrueLoanInterest(stub, args[0], loan, txTime.Truncate(24*time.Hour), &journal)
+		if err != nil {
+			return shim.Error("Interest accrual failed for loanID " + args[0] + ":" + err.Error())
+		}
+	}
+	if !journal.Empty() {
+		_, err = journal.Post(stub)
+		if err != nil {
+			return shim.Error("Extension wallets(extendLoan) " + err.Error())
+		}
+	}
+
+	//Calling instrument chaincode to take the instrument out of overdue along with the loan
+	if previousStatus == "overdue" && loan.LoanStatus == "disbursed" && loan.InstNum != "" {
+		argsList := []string{loan.InstNum, loan.SellerBusinessID, "disbursed"}
+		argsListStr := strings.Join(argsList, ",")
+		chaincodeArgs := toChaincodeArgs("updateInsStatus", argsListStr)
+		response := stub.InvokeChaincode("instrumentcc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error("Instrument status(extendLoan) " + response.Message)
+		}
+	}
+
+	loanBytes, _ = json.Marshal(loan)
+	err = stub.PutState(args[0], loanBytes)
+	if err != nil {
+		return shim.Error("Error in loan updation " + err.Error())
+	}
+	if previousStatus != loan.LoanStatus {
+		err = updateLoanStatusIndex(stub, args[0], previousStatus, loan.LoanStatus)
+		if err != nil {
+			return shim.Error("Error in loan status index updation " + err.Error())
+		}
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// checkExtension keeps the new due date within the program end date and its extension limit
+func checkExtension(stub shim.ChaincodeStubInterface, loan loanInfo, newDueDate time.Time) error {
+
+	// ProgramStartDate,ProgramEndDate,DiscountPeriod,MaxExtensionDays,MinTenorPercent
+	chaincodeArgs := toChaincodeArgs("getProgramTerms", loan.ProgramID)
+	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+	programTerms := strings.Split(string(response.Payload), ",")
+	pEndDate, err := time.Parse("02/01/2006", programTerms[1])
+	if err != nil {
+		return err
+	}
+	maxExtensionDays, err := strconv.ParseInt(programTerms[3], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if newDueDate.After(pEndDate) {
+		return fmt.Errorf("New due date %s is after the end date %s of program %s", newDueDate.Format("02/01/2006"), programTerms[1], loan.ProgramID)
+	}
+	extensionDays := int64(newDueDate.Sub(loan.OriginalDueDate).Hours() / 24)
+	if maxExtensionDays > 0 && extensionDays > maxExtensionDays {
+		return fmt.Errorf("Extension of %d days over the original due date %s exceeds the %d days allowed by program %s", extensionDays, loan.OriginalDueDate.Format("02/01/2006"), maxExtensionDays, loan.ProgramID)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type accrualInfo struct {
+	LoanID       string
+	AccrualDate  time.Time
+	DisbursedBal int64
+	ROI          float64
+	Interest     int64
+}
+
+func accrueInterest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> as of date (dd/mm/yyyy)
+		Accrues interest day by day for every disbursed loan up to and including
+		the as of date. Each loan-day is marked in the ledger so the same day
+		is never accrued twice.
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in accrueInterest(loan) (required:1) given:" + xLenStr)
+	}
+
+	asOfDate, err := time.Parse("02/01/2006", args[0])
+	if err != nil {
+		return shim.Error("Invalid as of date in accrueInterest(loan):" + err.Error())
+	}
+
+	loansIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the loans (accrueInterest):" + err.Error())
+	}
+	defer loansIterator.Close()
+
+	// Loans of the same business credit its Interest O/s Wallet, so the whole
+	// run is posted as one journal and every wallet is written once
+	journal := common.Journal{}
+	accrued := map[string]int64{}
+	for loansIterator.HasNext() {
+		loanData, err := loansIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the loans (accrueInterest):" + err.Error())
+		}
+
+		loan := loanInfo{}
+		err = json.Unmarshal(loanData.Value, &loan)
+		if err != nil || loan.LoanAccruedInterestWalletID == "" {
+			continue
+		}
+		if (loan.LoanStatus != "disbursed") && (loan.LoanStatus != "part disbursed") && (loan.LoanStatus != "overdue") {
+			continue
+		}
+
+		interest, err := accrueLoanInterest(stub, loanData.Key, loan, asOfDate, &journal)
+		if err != nil {
+			return shim.Error("Interest accrual failed for loanID " + loanData.Key + ":" + err.Error())
+		}
+		if interest != 0 {
+			accrued[loanData.Key] = interest
+		}
+	}
+
+	if !journal.Empty() {
+		_, err = journal.Post(stub)
+		if err != nil {
+			return shim.Error("Interest accrual wallets " + err.Error())
+		}
+	}
+
+	accruedBytes, _ := json.Marshal(accrued)
+	return shim.Success(accruedBytes)
+}
+
+// accrueLoanInterest marks the days of the loan accrued up to asOfDate and
+// adds the interest to the Loan Accrued Interest and Business Interest O/s
+// Wallets in the journal, which the caller posts
+func accrueLoanInterest(stub shim.ChaincodeStubInterface, loanID string, loan loanInfo, asOfDate time.Time, journal *common.Journal) (int64, error) {
+
+	// Interest runs from the value date up to the day before the due date
+	startDate := time.Date(loan.ValueDate.Year(), loan.ValueDate.Month(), loan.ValueDate.Day(), 0, 0, 0, 0, time.UTC)
+	endDate := asOfDate
+	if !loan.DueDate.After(asOfDate) {
+		endDate = loan.DueDate.AddDate(0, 0, -1)
+	}
+	if endDate.Before(startDate) {
+		return 0, nil
+	}
+
+	disbursedBals, err := disbursedBalances(stub, loan, startDate, endDate)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		disbursedBal := disbursedBals[day.Format("2006-01-02")]
+		if disbursedBal <= 0 {
+			continue
+		}
+		accrualKey, err := stub.CreateCompositeKey("LoanID~AccrualDate", []string{loanID, day.Format("2006-01-02")})
+		if err != nil {
+			return 0, errors.New("Unable to create composite key LoanID~AccrualDate:" + err.Error())
+		}
+		ifExists, err := stub.GetState(accrualKey)
+		if err != nil {
+			return 0, err
+		} else if ifExists != nil {
+			continue
+		}
+
+		interest := int64(math.Round(float64(disbursedBal) * loan.ROI / 36500))
+		accrual := accrualInfo{loanID, day, disbursedBal, loan.ROI, interest}
+		accrualBytes, _ := json.Marshal(accrual)
+		err = stub.PutState(accrualKey, accrualBytes)
+		if err != nil {
+			return 0, err
+		}
+		total += interest
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+
+	chaincodeArgs := toChaincodeArgs("getWalletID", loan.ExposureBusinessID, "interestOut")
+	response := stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, errors.New(response.Message)
+	}
+
+	//Crediting the Loan Accrued Interest Wallet and the Business Interest O/s Wallet mirroring it
+	journal.Credit(loan.LoanAccruedInterestWalletID, total, true, loanRow(stub, "1AI", loanID, loan, asOfDate, "accrued interest", total, "system"))
+	journal.Contra(string(response.Payload), "credit", total, loanRow(stub, "2AI", loanID, loan, asOfDate, "accrued interest", total, "system"))
+	return total, nil
+}
+
+func getAccruedAfter(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> LoanID
+		args[1] -> date (dd/mm/yyyy)
+		Returns the interest accrued on the loan for the days after the date
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getAccruedAfter(loan) (required:2) given:" + xLenStr)
+	}
+	afterDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid date in getAccruedAfter(loan):" + err.Error())
+	}
+
+	accrualsIterator, err := stub.GetStateByPartialCompositeKey("LoanID~AccrualDate", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to fetch the accruals (getAccruedAfter):" + err.Error())
+	}
+	defer accrualsIterator.Close()
+
+	var interest int64
+	for accrualsIterator.HasNext() {
+		accrualData, err := accrualsIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the accruals (getAccruedAfter):" + err.Error())
+		}
+		accrual := accrualInfo{}
+		err = json.Unmarshal(accrualData.Value, &accrual)
+		if err != nil {
+			return shim.Error("Unable to parse the accrual (getAccruedAfter):" + err.Error())
+		}
+		if accrual.AccrualDate.After(afterDate) {
+			interest += accrual.Interest
+		}
+	}
+	return shim.Success([]byte(strconv.FormatInt(interest, 10)))
+}
+
+// disbursedBalances returns the balance of the Loan Disbursed Wallet at the
+// close of every day from startDate to endDate, from its wallet statement
+func disbursedBalances(stub shim.ChaincodeStubInterface, loan loanInfo, startDate time.Time, endDate time.Time) (map[string]int64, error) {
+
+	chaincodeArgs := toChaincodeArgs("getWalletStatement", loan.LoanDisbursedWalletID, startDate.Format("02/01/2006"), endDate.Format("02/01/2006"))
+	response := stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return nil, errors.New("Loan Disbursed Wallet statement " + response.Message)
+	}
+	statement := struct {
+		OpeningBal int64
+		Entries    []struct {
+			TxnDate time.Time
+			TxnBal  int64
+		}
+	}{}
+	err := json.Unmarshal(response.Payload, &statement)
+	if err != nil {
+		return nil, errors.New("Unable to parse the Loan Disbursed Wallet statement " + err.Error())
+	}
+
+	// A day is accrued on what the statement shows was outstanding on it, a
+	// loan with no movements in its statement accrues nothing; rows written
+	// before the wallet statements are brought in by txnbalcc reindexTxnBal
+	balance := statement.OpeningBal
+
+	balances := map[string]int64{}
+	next := 0
+	for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		for next < len(statement.Entries) && !statement.Entries[next].TxnDate.After(day) {
+			balance = statement.Entries[next].TxnBal
+			next++
+		}
+		balances[day.Format("2006-01-02")] = balance
+	}
+	return balances, nil
+}
+
+// loanRow is the Txn_Bal_Ledger row of a loan posting, keyed by the posting,
+// the loan and the date
+func loanRow(stub shim.ChaincodeStubInterface, keyPrefix string, loanID string, loan loanInfo, txnDate time.Time, txnType string, amt int64, by string) common.TxnRow {
+	return common.TxnRow{
+		TxnBalID: keyPrefix + loanID + txnDate.Format("20060102"),
+		TxnID:    stub.GetTxID(),
+		TxnDate:  txnDate.Format("02/01/2006"),
+		LoanID:   loanID,
+		InsID:    loan.InstNum,
+		TxnType:  txnType,
+		Amt:      strconv.FormatInt(amt, 10),
+		By:       by,
+		Currency: loan.Currency,
+	}
+}
+
+func getWalletValue(stub shim.ChaincodeStubInterface, walletID string) (int64, error) {
+
+	walletArgs := toChaincodeArgs("getWallet", walletID)
+	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
+	if walletResponse.Status != shim.OK {
+		return 0, errors.New(walletResponse.Message)
+	}
+	balance, err := strconv.ParseInt(string(walletResponse.Payload), 10, 64)
+	if err != nil {
+		return 0, errors.New("Error in converting the wallet balance (loan)")
+	}
+	return balance, nil
+}
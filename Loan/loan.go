@@ -5,12 +5,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -32,6 +34,19 @@ type loanInfo struct {
 	LoanAccruedInterestWalletID string    //[12]
 	BuyerBusinessID             string    //[13]
 	SellerBusinessID            string    //[14]
+	Currency                    string    //[16]//amounts are in minor units of this currency
+	InsAmount                   int64     //instrument amount in its own currency
+	InsCurrency                 string
+	FXRate                      float64   //InsCurrency to Currency rate applied at sanction
+	ContractualDueDate          time.Time //DueDate before the business day roll
+	OriginalDueDate             time.Time //ContractualDueDate at sanction
+	DueDateRevisions            []dueDateRevision
+	AssetClass                  string    //standard, sma-0, sma-1, sma-2 or npa
+	DPD                         int64     //days past due as of ClassDate
+	ClassDate                   time.Time //as of date of the last classification
+	Provision                   int64     //held in the bank provision wallet against this loan
+	WrittenOff                  int64     //principal written off
+	Recovered                   int64     //recovered against WrittenOff
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -41,6 +56,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newLoanInfo" {
 		//Creates a new Loan Data
 		return newLoanInfo(stub, args)
@@ -62,16 +82,59 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	} else if function == "getSellerID" {
 		//Returns the Seller Id
 		return getSellerID(stub, args[0])
+	} else if function == "accrueInterest" {
+		//Accrues daily interest on all the disbursed loans
+		return accrueInterest(stub, args)
+	} else if function == "getAccruedAfter" {
+		//Returns the interest accrued on the loan for the days after a date
+		return getAccruedAfter(stub, args)
+	} else if function == "markOverdue" {
+		//Moves the loans past their due date to overdue
+		return markOverdue(stub, args)
+	} else if function == "queryLoans" {
+		//Returns a page of loans matching the filter
+		return queryLoans(stub, args)
+	} else if function == "reindexLoans" {
+		//Writes the query indexes for the existing loans
+		return reindexLoans(stub, args)
+	} else if function == "getHistory" {
+		//Returns every past version of the record with its submitter
+		return getHistory(stub, args)
+	} else if function == "extendLoan" {
+		//Moves the due date of the loan with an optional extension fee
+		return extendLoan(stub, args)
+	} else if function == "classifyLoans" {
+		//Classifies the loans by days past due and posts the provisions
+		return classifyLoans(stub, args)
+	} else if function == "setAssetClassConfig" {
+		//Sets the days past due thresholds and the provision rates
+		return setAssetClassConfig(stub, args)
+	} else if function == "getAssetClassConfig" {
+		//Returns the days past due thresholds and the provision rates
+		return getAssetClassConfig(stub, args)
+	} else if function == "writeOffLoan" {
+		//Writes off the outstanding principal of an NPA loan
+		return writeOffLoan(stub, args)
+	} else if function == "recoverWriteOff" {
+		//Records a recovery against a written off loan
+		return recoverWriteOff(stub, args)
 	}
 	return shim.Error("No function named " + function + " in Loanssssssssssss")
 }
 
 func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 15 {
+	if (len(args) != 16) && (len(args) != 17) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in newLoanInfo(loan) (required:15) given: " + xLenStr)
+		return shim.Error("Invalid number of arguments in newLoanInfo(loan) (required:16 or 17) given: " + xLenStr)
 	}
+
+	//Loan currency -> args[16] (optional, INR by default)
+	currency := "INR"
+	if len(args) == 17 {
+		currency = strings.ToUpper(args[16])
+	}
+
 	//Checking existence of loanID
 	response := loanIDexists(stub, args[0])
 	if response.Status != shim.OK {
@@ -86,29 +149,47 @@ func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	}
 
 	//Checking if Instrument ID is Instrument Ref. No.
-	chaincodeArgs = toChaincodeArgs("getSellerIDnAmt", args[1])
+	chaincodeArgs = toChaincodeArgs("getSellerIDnAmt", args[1], args[15])
 	response = stub.InvokeChaincode("instrumentcc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
 		return shim.Error("Instrument refrence no " + args[1] + " does not exits")
 	}
 
 	// getting the sanction amount from the instrument
-	instAmtStr := strings.Split(string(response.Payload), ",")[1]
-	instAmt, err := strconv.ParseInt(instAmtStr, 10, 64)
+	instInfo := strings.Split(string(response.Payload), ",")
+	insAmt, err := strconv.ParseInt(instInfo[1], 10, 64)
 	if err != nil {
 		return shim.Error("Unable to parse instAmt(loan):" + err.Error())
 	}
+	insCurrency := instInfo[2]
+
+	//SanctionDate ->sDate, taken from the transaction so that every peer endorses the same date and rate
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sDate := time.Unix(txTimestamp.GetSeconds(), int64(txTimestamp.GetNanos())).UTC()
+
+	// Instrument amount in the loan currency at the registry rate of the sanction date
+	fxRate, err := common.FXRate(stub, insCurrency, currency, sDate)
+	if err != nil {
+		return shim.Error("Unable to convert the instrument amount(loan):" + err.Error())
+	}
+	instAmt := common.ConvertAtRate(insAmt, fxRate)
 
 	//Getting the discount percentage
 	chaincodeArgs = toChaincodeArgs("discountPercentage", args[3], args[2])
 	response = stub.InvokeChaincode("pprcc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
-		return shim.Error("PprId " + args[8] + " does not exits")
+	if response.Status != shim.OK {
+		return shim.Error("Unable to get the discount percentage(loan):" + response.Message)
 	}
 
 	discountPercentStr := string(response.Payload)
-	discountPercent, _ := strconv.ParseInt(discountPercentStr, 10, 64)
-	amt := instAmt - ((discountPercent * instAmt) / 100)
+	discountPercent, err := strconv.ParseFloat(discountPercentStr, 64)
+	if err != nil {
+		return shim.Error("Unable to parse the discount percentage(loan):" + err.Error())
+	}
+	amt := instAmt - int64(math.Round(float64(instAmt)*discountPercent/100))
 
 	//SanctionAmt -> sAmt
 	sAmt, err := strconv.ParseInt(args[4], 10, 64)
@@ -116,18 +197,33 @@ func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error(err.Error())
 	}
 
-	if sAmt > amt && sAmt == 0 {
+	if sAmt > amt || sAmt == 0 {
 		return shim.Error("Sanction amount exceeds the required value or it is zero : " + args[4])
 	}
 
-	//SanctionDate ->sDate
-	sDate := time.Now()
-
 	roi, err := strconv.ParseFloat(args[7], 32)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	//ROI must fall in the band of the exposure business
+	chaincodeArgs = toChaincodeArgs("getBusinessInfo", args[2])
+	response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+	roiBand := struct {
+		MaxROI int64
+		MinROI int64
+	}{}
+	err = json.Unmarshal(response.Payload, &roiBand)
+	if err != nil {
+		return shim.Error("Unable to parse the business info(loan):" + err.Error())
+	}
+	if roi < float64(roiBand.MinROI) || roi > float64(roiBand.MaxROI) {
+		return shim.Error(fmt.Sprintf("ROI %s is outside the band %d-%d of business %s", args[7], roiBand.MinROI, roiBand.MaxROI, args[2]))
+	}
+
 	//Parsing into date for storage but hh:mm:ss will also be stored as
 	//00:00:00 .000Z with the date
 	//DueDate -> dDate
@@ -135,10 +231,12 @@ func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if dDate.Weekday().String() == "Sunday" {
-		fmt.Println("Since the due date falls on sunday, due date is extended to Monday(loan) : ", dDate.AddDate(0, 0, 1))
+	//Rolling the due date to a business day, the contractual date is kept alongside
+	contractualDueDate := dDate
+	dDate, err = common.AdjustDueDate(stub, args[3], contractualDueDate)
+	if err != nil {
+		return shim.Error("Unable to adjust the due date(loan):" + err.Error())
 	}
-	dDate = dDate.AddDate(0, 0, 1)
 
 	//Converting the incoming date from Dd/mm/yy:hh:mm:ss to Dd/mm/yyThh:mm:ss for parsing
 	vDateStr := args[5][:10]
@@ -158,21 +256,21 @@ func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	hash.Write([]byte(LoanDisbursedWalletStr))
 	md := hash.Sum(nil)
 	LoanDisbursedWalletIDsha := hex.EncodeToString(md)
-	createWallet(stub, LoanDisbursedWalletIDsha, args[11])
+	createWallet(stub, LoanDisbursedWalletIDsha, args[11], currency)
 
 	// Hashing LoanChargesWalletID
 	LoanChargesWalletStr := args[12] + "LoanChargesWallet"
 	hash.Write([]byte(LoanChargesWalletStr))
 	md = hash.Sum(nil)
 	LoanChargesWalletIDsha := hex.EncodeToString(md)
-	createWallet(stub, LoanChargesWalletIDsha, args[12])
+	createWallet(stub, LoanChargesWalletIDsha, args[12], currency)
 
 	// Hashing LoanAccruedInterestWalletID
 	LoanAccruedInterestWalletStr := args[13] + "LoanAccruedInterestWallet"
 	hash.Write([]byte(LoanAccruedInterestWalletStr))
 	md = hash.Sum(nil)
 	LoanAccruedInterestWalletIDsha := hex.EncodeToString(md)
-	createWallet(stub, LoanAccruedInterestWalletIDsha, args[13])
+	createWallet(stub, LoanAccruedInterestWalletIDsha, args[13], currency)
 
 	//Checking existence of BuyerBusinessID
 	chaincodeArgs = toChaincodeArgs("busIDexists", args[14])
@@ -188,14 +286,52 @@ func newLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error("SellerBusinessID " + args[15] + " does not exits")
 	}
 
-	loan := loanInfo{args[1], args[2], args[3], sAmt, sDate, args[6], roi, dDate, vDate, "sanctioned", LoanDisbursedWalletIDsha, LoanChargesWalletIDsha, LoanAccruedInterestWalletIDsha, args[14], args[15]}
+	//Sanction must fit in the PPR limit at or above the PPR roi
+	chaincodeArgs = toChaincodeArgs("recordSanction", args[3], args[2], strconv.FormatInt(sAmt, 10), currency, args[7])
+	response = stub.InvokeChaincode("pprcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+
+	//Sanction must fit in the program limit
+	chaincodeArgs = toChaincodeArgs("updateUtilization", args[3], "sanction", strconv.FormatInt(sAmt, 10), currency, args[0])
+	response = stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+
+	//Sanction must fit in the business limit of the exposure business and the buyer
+	businessIDs := []string{args[2]}
+	if args[14] != args[2] {
+		businessIDs = append(businessIDs, args[14])
+	}
+	for _, businessID := range businessIDs {
+		chaincodeArgs = toChaincodeArgs("updateExposure", businessID, args[3], "sanction", strconv.FormatInt(sAmt, 10), currency, args[0])
+		response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error(response.Message)
+		}
+	}
+
+	loan := loanInfo{args[1], args[2], args[3], sAmt, sDate, args[6], roi, dDate, vDate, "sanctioned", LoanDisbursedWalletIDsha, LoanChargesWalletIDsha, LoanAccruedInterestWalletIDsha, args[14], args[15], currency, insAmt, insCurrency, fxRate, contractualDueDate, contractualDueDate, []dueDateRevision{}, "standard", 0, sDate, 0, 0, 0}
 	loanBytes, err := json.Marshal(loan)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	stub.PutState(args[0], loanBytes)
+	err = stub.PutState(args[0], loanBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putLoanIndexes(stub, args[0], loan)
+	if err != nil {
+		return shim.Error("Unable to index the loan(loan):" + err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	argsList := []string{args[1], args[14], "sanctioned"}
+	argsList := []string{args[1], args[15], "sanctioned"}
 	argsListStr := strings.Join(argsList, ",")
 	chaincodeArgs = toChaincodeArgs("updateInsStatus", argsListStr)
 	response = stub.InvokeChaincode("instrumentcc", chaincodeArgs, "myc")
@@ -227,14 +363,26 @@ func loanStatusSancAmt(stub shim.ChaincodeStubInterface, loanID string) pb.Respo
 	if err != nil {
 		return shim.Error("Error unmarshiling in loanstatus(loan):" + err.Error())
 	}
+	migrateLegacyLoan(&loan)
 
-	sancAmtString := strconv.FormatInt(loan.SanctionAmt, 64)
+	sancAmtString := strconv.FormatInt(loan.SanctionAmt, 10)
 	return shim.Success([]byte(loan.LoanStatus + "," + sancAmtString))
 }
 
 func getWalletID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	args = strings.Split(args[0], ",")
+	/*
+		args[0] -> LoanID
+		args[1] -> wallet type (accrued, charges or disbursed)
+		txnbalcc sends both as one comma separated argument
+	*/
+	if len(args) == 1 {
+		args = strings.Split(args[0], ",")
+	}
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getWalletID(loan) (required:2) given:" + xLenStr)
+	}
 	loanBytes, err := stub.GetState(args[0])
 	if err != nil {
 		return shim.Error(err.Error())
@@ -263,8 +411,8 @@ func getWalletID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	return shim.Success([]byte(walletID))
 }
-func createWallet(stub shim.ChaincodeStubInterface, walletID string, amt string) pb.Response {
-	chaincodeArgs := toChaincodeArgs("newWallet", walletID, amt)
+func createWallet(stub shim.ChaincodeStubInterface, walletID string, amt string, currency string) pb.Response {
+	chaincodeArgs := toChaincodeArgs("newWallet", walletID, amt, currency)
 	response := stub.InvokeChaincode("walletcc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
 		return shim.Error("Unable to create new wallet from business")
@@ -291,11 +439,13 @@ func getLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	migrateLegacyLoan(&loan)
+	loanBytes, _ = json.Marshal(loan)
 
 	loanString := fmt.Sprintf("%+v", loan)
 	fmt.Printf("Loan Info:%s\n ", loanString)
 
-	return shim.Success(nil)
+	return shim.Success(loanBytes)
 }
 
 func getSellerID(stub shim.ChaincodeStubInterface, loanID string) pb.Response {
@@ -319,8 +469,14 @@ func updateLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response
 
 	/*
 		Updating the variables for loan structure
+		args[0] -> loanID
+		disbursement: args[1] -> status ("disbursed"/"part disbursed"), args[2] -> "disbursement"
+		repayment:    args[1] -> "repayment", args[2] -> status ("collected"/"part collected")
 	*/
-	args = strings.Split(args[0], ",")
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in updateLoanInfo(loan) (required:3) given:" + xLenStr)
+	}
 	loanBytes, err := stub.GetState(args[0])
 	if err != nil {
 		return shim.Error(err.Error())
@@ -333,19 +489,29 @@ func updateLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response
 	if err != nil {
 		return shim.Error("error in unmarshiling loan: in updateLoanInfo" + err.Error())
 	}
+	migrateLegacyLoan(&loan)
 
 	// To change the LoanStatus from "sanction" to "disbursed"
 	if args[2] == "disbursement" {
-		if (loan.LoanStatus != "sanctioned") || (loan.LoanStatus != "part disbursed") {
+		if (loan.LoanStatus != "sanctioned") && (loan.LoanStatus != "part disbursed") {
 			return shim.Error("Loan is not Sanctioned, so cannot be disbursed/ part Disbursed : " + loan.LoanStatus)
 		}
 		//Updating Loan status for disbursement
+		previousStatus := loan.LoanStatus
 		loan.LoanStatus = args[1]
 		loanBytes, _ := json.Marshal(loan)
 		err = stub.PutState(args[0], loanBytes)
 		if err != nil {
 			return shim.Error("Error in loan updation " + err.Error())
 		}
+		err = updateLoanStatusIndex(stub, args[0], previousStatus, loan.LoanStatus)
+		if err != nil {
+			return shim.Error("Error in loan status index updation " + err.Error())
+		}
+		err = common.RecordSubmitter(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
 		//Calling instrument chaincode to update the status
 		argsList := []string{loan.InstNum, loan.SellerBusinessID, "disbursed"}
@@ -358,22 +524,43 @@ func updateLoanInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response
 		return shim.Success([]byte("sanction updated succesfully"))
 
 	} else if (args[1] == "repayment") && ((args[2] == "collected") || (args[2] == "part collected")) {
-		if loan.LoanStatus != "disbursed" {
-			return shim.Error("Loan is not Sanctioned, so cannot be disbursed")
+		//A repayment can come in on an overdue loan or after an earlier part collection
+		if (loan.LoanStatus != "disbursed") && (loan.LoanStatus != "overdue") && (loan.LoanStatus != "part collected") {
+			return shim.Error("Loan is not disbursed, overdue or part collected, so cannot be collected : " + loan.LoanStatus)
 		}
 		//Updating Loan status for repayment
+		previousStatus := loan.LoanStatus
 		loan.LoanStatus = args[2]
 		loanBytes, _ = json.Marshal(loan)
 		err = stub.PutState(args[0], loanBytes)
 		if err != nil {
 			return shim.Error("Error in loan status updation " + err.Error())
 		}
+		err = updateLoanStatusIndex(stub, args[0], previousStatus, loan.LoanStatus)
+		if err != nil {
+			return shim.Error("Error in loan status index updation " + err.Error())
+		}
+		err = common.RecordSubmitter(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
 		return shim.Success([]byte("Successfully updated loan status with data from repayment"))
 	}
 	return shim.Error("Invalid info for update loan")
 }
 
+// Loans written before minor units carry the sanction amount in rupees and no currency
+func migrateLegacyLoan(loan *loanInfo) {
+	if loan.Currency == "" {
+		loan.SanctionAmt = loan.SanctionAmt * 100
+		loan.Currency = "INR"
+	}
+	if loan.AssetClass == "" {
+		loan.AssetClass = "standard"
+	}
+}
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
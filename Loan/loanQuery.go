@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Composite key indexes on the loan fields used by queryLoans on LevelDB peers
+var loanIndexes = []string{"LoanStatus~LoanID", "ProgramID~LoanID", "BuyerBusinessID~LoanID", "SellerBusinessID~LoanID", "ExposureBusinessID~LoanID"}
+
+type loanFilter struct {
+	LoanStatus         string
+	ProgramID          string
+	BuyerBusinessID    string
+	SellerBusinessID   string
+	ExposureBusinessID string
+	DueDateFrom        string //dd/mm/yyyy
+	DueDateTo          string //dd/mm/yyyy
+}
+
+type loanRecord struct {
+	LoanID string
+	Loan   loanInfo
+}
+
+type loanQueryResult struct {
+	Records  []loanRecord
+	Count    int
+	Bookmark string //empty when there are no more records
+}
+
+// Prefixes of the bookmarks issued from the CouchDB selector and from the composite key indexes
+const (
+	couchBookmark = "couch"
+	indexBookmark = "index"
+)
+
+func loanIndexValues(loan loanInfo) []string {
+	return []string{loan.LoanStatus, loan.ProgramID, loan.BuyerBusinessID, loan.SellerBusinessID, loan.ExposureBusinessID}
+}
+
+func putLoanIndexes(stub shim.ChaincodeStubInterface, loanID string, loan loanInfo) error {
+
+	value := []byte{0x00}
+	for i, indexName := range loanIndexes {
+		indexKey, err := stub.CreateCompositeKey(indexName, []string{loanIndexValues(loan)[i], loanID})
+		if err != nil {
+			return errors.New("Unable to create composite key " + indexName + ":" + err.Error())
+		}
+		err = stub.PutState(indexKey, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateLoanStatusIndex moves the loan from the old status index entry to the new one
+func updateLoanStatusIndex(stub shim.ChaincodeStubInterface, loanID string, oldStatus string, newStatus string) error {
+
+	oldKey, err := stub.CreateCompositeKey("LoanStatus~LoanID", []string{oldStatus, loanID})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(oldKey)
+	if err != nil {
+		return err
+	}
+	newKey, err := stub.CreateCompositeKey("LoanStatus~LoanID", []string{newStatus, loanID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(newKey, []byte{0x00})
+}
+
+func reindexLoans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		Writes the query indexes for the loans created before queryLoans
+	*/
+	loansIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the loans (reindexLoans):" + err.Error())
+	}
+	defer loansIterator.Close()
+
+	count := 0
+	for loansIterator.HasNext() {
+		loanData, err := loansIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the loans (reindexLoans):" + err.Error())
+		}
+		loan := loanInfo{}
+		err = json.Unmarshal(loanData.Value, &loan)
+		if err != nil || loan.InstNum == "" {
+			continue
+		}
+		err = putLoanIndexes(stub, loanData.Key, loan)
+		if err != nil {
+			return shim.Error("Unable to index loanID " + loanData.Key + ":" + err.Error())
+		}
+		count++
+	}
+	return shim.Success([]byte(strconv.Itoa(count)))
+}
+
+func queryLoans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> JSON filter {"LoanStatus":"..","ProgramID":"..","BuyerBusinessID":"..",
+				   "SellerBusinessID":"..","ExposureBusinessID":"..","DueDateFrom":"dd/mm/yyyy","DueDateTo":"dd/mm/yyyy"}
+		args[1] -> page size
+		args[2] -> bookmark from the previous page (optional), only valid on peers with the same state database
+	*/
+	if (len(args) != 2) && (len(args) != 3) {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in queryLoans(loan) (required:2 or 3) given:" + xLenStr)
+	}
+
+	filter := loanFilter{}
+	err := json.Unmarshal([]byte(args[0]), &filter)
+	if err != nil {
+		return shim.Error("Invalid filter in queryLoans(loan):" + err.Error())
+	}
+
+	var dueFrom, dueTo time.Time
+	if filter.DueDateFrom != "" {
+		dueFrom, err = time.Parse("02/01/2006", filter.DueDateFrom)
+		if err != nil {
+			return shim.Error("Invalid DueDateFrom in queryLoans(loan):" + err.Error())
+		}
+	}
+	if filter.DueDateTo != "" {
+		dueTo, err = time.Parse("02/01/2006", filter.DueDateTo)
+		if err != nil {
+			return shim.Error("Invalid DueDateTo in queryLoans(loan):" + err.Error())
+		}
+	}
+
+	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil || pageSize <= 0 {
+		return shim.Error("Invalid page size in queryLoans(loan): " + args[1])
+	}
+
+	// The bookmark names the store that issued it, the position in one is meaningless to the other
+	backend := ""
+	bookmark := ""
+	if len(args) == 3 && args[2] != "" {
+		parts := strings.SplitN(args[2], ":", 2)
+		if len(parts) != 2 || (parts[0] != couchBookmark && parts[0] != indexBookmark) {
+			return shim.Error("Invalid bookmark in queryLoans(loan): " + args[2])
+		}
+		backend = parts[0]
+		bookmark = parts[1]
+	}
+
+	// CouchDB peers answer the selector, LevelDB peers reject rich queries
+	// and are served from the composite key indexes
+	richQuery := backend != indexBookmark
+	if !richQuery {
+		richQuery, err = richQuerySupported(stub)
+		if err != nil {
+			return shim.Error("queryLoans " + err.Error())
+		}
+		if richQuery {
+			return shim.Error("Bookmark " + args[2] + " was issued by a LevelDB peer, this peer runs CouchDB")
+		}
+	}
+
+	var result loanQueryResult
+	if richQuery {
+		result, err = queryLoansCouch(stub, filter, dueFrom, dueTo, int32(pageSize), bookmark)
+		if err == nil {
+			resultBytes, _ := json.Marshal(result)
+			return shim.Success(resultBytes)
+		}
+		if !richQueryUnsupported(err) {
+			return shim.Error("queryLoans " + err.Error())
+		}
+		if backend == couchBookmark {
+			return shim.Error("Bookmark " + args[2] + " was issued by a CouchDB peer, this peer runs LevelDB")
+		}
+		fmt.Println("Rich query unavailable, using the loan indexes (queryLoans):", err)
+	}
+
+	result, err = queryLoansIndexed(stub, filter, dueFrom, dueTo, int(pageSize), bookmark)
+	if err != nil {
+		return shim.Error("queryLoans " + err.Error())
+	}
+
+	resultBytes, _ := json.Marshal(result)
+	return shim.Success(resultBytes)
+}
+
+// richQueryUnsupported tells a LevelDB state database refusing a rich query
+// apart from a failure of the query itself
+func richQueryUnsupported(err error) bool {
+	return strings.Contains(err.Error(), "not supported for leveldb")
+}
+
+// richQuerySupported probes the state database of the peer with a one record query
+func richQuerySupported(stub shim.ChaincodeStubInterface) (bool, error) {
+
+	resultsIterator, _, err := stub.GetQueryResultWithPagination(`{"selector":{"InstNum":{"$gt":""}}}`, 1, "")
+	if err != nil {
+		if richQueryUnsupported(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	resultsIterator.Close()
+	return true, nil
+}
+
+func queryLoansCouch(stub shim.ChaincodeStubInterface, filter loanFilter, dueFrom time.Time, dueTo time.Time, pageSize int32, bookmark string) (loanQueryResult, error) {
+
+	result := loanQueryResult{Records: []loanRecord{}}
+
+	// InstNum is present only on loans, it keeps the accrual records out of the results
+	selector := map[string]interface{}{"InstNum": map[string]interface{}{"$gt": ""}}
+	fields := map[string]string{
+		"LoanStatus":         filter.LoanStatus,
+		"ProgramID":          filter.ProgramID,
+		"BuyerBusinessID":    filter.BuyerBusinessID,
+		"SellerBusinessID":   filter.SellerBusinessID,
+		"ExposureBusinessID": filter.ExposureBusinessID,
+	}
+	for field, value := range fields {
+		if value != "" {
+			selector[field] = value
+		}
+	}
+	dueDate := map[string]interface{}{}
+	if !dueFrom.IsZero() {
+		dueDate["$gte"] = dueFrom
+	}
+	if !dueTo.IsZero() {
+		dueDate["$lte"] = dueTo
+	}
+	if len(dueDate) != 0 {
+		selector["DueDate"] = dueDate
+	}
+
+	queryBytes, _ := json.Marshal(map[string]interface{}{"selector": selector})
+	resultsIterator, metadata, err := stub.GetQueryResultWithPagination(string(queryBytes), pageSize, bookmark)
+	if err != nil {
+		return result, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		loanData, err := resultsIterator.Next()
+		if err != nil {
+			return result, err
+		}
+		loan := loanInfo{}
+		err = json.Unmarshal(loanData.Value, &loan)
+		if err != nil {
+			return result, err
+		}
+		migrateLegacyLoan(&loan)
+		result.Records = append(result.Records, loanRecord{loanData.Key, loan})
+	}
+
+	result.Count = len(result.Records)
+	if int32(result.Count) == pageSize {
+		result.Bookmark = couchBookmark + ":" + metadata.Bookmark
+	}
+	return result, nil
+}
+
+func queryLoansIndexed(stub shim.ChaincodeStubInterface, filter loanFilter, dueFrom time.Time, dueTo time.Time, pageSize int, bookmark string) (loanQueryResult, error) {
+
+	result := loanQueryResult{Records: []loanRecord{}}
+
+	// The first filtered field picks the index, the rest are matched on the loan.
+	// Both the index and the plain range are ordered by loanID, so the bookmark
+	// is the last loanID returned.
+	indexName := ""
+	indexValue := ""
+	filterValues := []string{filter.LoanStatus, filter.ProgramID, filter.BuyerBusinessID, filter.SellerBusinessID, filter.ExposureBusinessID}
+	for i, value := range filterValues {
+		if value != "" {
+			indexName = loanIndexes[i]
+			indexValue = value
+			break
+		}
+	}
+
+	var loansIterator shim.StateQueryIteratorInterface
+	var err error
+	if indexName == "" {
+		loansIterator, err = stub.GetStateByRange("", "")
+	} else {
+		loansIterator, err = stub.GetStateByPartialCompositeKey(indexName, []string{indexValue})
+	}
+	if err != nil {
+		return result, err
+	}
+	defer loansIterator.Close()
+
+	for loansIterator.HasNext() {
+		data, err := loansIterator.Next()
+		if err != nil {
+			return result, err
+		}
+
+		loanID := data.Key
+		loanBytes := data.Value
+		if indexName != "" {
+			_, keyParts, err := stub.SplitCompositeKey(data.Key)
+			if err != nil {
+				return result, err
+			}
+			loanID = keyParts[1]
+		}
+		if (bookmark != "") && (loanID <= bookmark) {
+			continue
+		}
+		if indexName != "" {
+			loanBytes, err = stub.GetState(loanID)
+			if err != nil {
+				return result, err
+			}
+		}
+
+		loan := loanInfo{}
+		err = json.Unmarshal(loanBytes, &loan)
+		if err != nil || loan.InstNum == "" {
+			continue
+		}
+		migrateLegacyLoan(&loan)
+		if !loanMatches(loan, filter, dueFrom, dueTo) {
+			continue
+		}
+
+		if len(result.Records) == pageSize {
+			result.Bookmark = indexBookmark + ":" + result.Records[pageSize-1].LoanID
+			break
+		}
+		result.Records = append(result.Records, loanRecord{loanID, loan})
+	}
+
+	result.Count = len(result.Records)
+	return result, nil
+}
+
+func loanMatches(loan loanInfo, filter loanFilter, dueFrom time.Time, dueTo time.Time) bool {
+
+	filterValues := []string{filter.LoanStatus, filter.ProgramID, filter.BuyerBusinessID, filter.SellerBusinessID, filter.ExposureBusinessID}
+	for i, value := range loanIndexValues(loan) {
+		if (filterValues[i] != "") && (filterValues[i] != value) {
+			return false
+		}
+	}
+	if !dueFrom.IsZero() && loan.DueDate.Before(dueFrom) {
+		return false
+	}
+	if !dueTo.IsZero() && loan.DueDate.After(dueTo) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testStub is a MockStub submitted by an Org1MSP identity, the MockStub has no creator
+type testStub struct {
+	*shim.MockStub
+	creator []byte
+}
+
+func (s testStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func testCreator(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "maker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: certPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+// recordingCC answers every call with Payload and records the calls it got
+type recordingCC struct {
+	Payload []byte
+	Calls   [][]string
+}
+
+func (c *recordingCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *recordingCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	c.Calls = append(c.Calls, append([]string{function}, args...))
+	return shim.Success(c.Payload)
+}
+
+func newTestStub(t *testing.T, peers map[string]shim.Chaincode) testStub {
+	stub := testStub{shim.NewMockStub("loancc", new(chainCode)), testCreator(t)}
+	for name, cc := range peers {
+		stub.MockPeerChaincode(name+"/myc", shim.NewMockStub(name, cc))
+	}
+	return stub
+}
+
+func putLoan(t *testing.T, stub testStub, loanID string, loan loanInfo) {
+	stub.MockTransactionStart("put" + loanID)
+	loanBytes, _ := json.Marshal(loan)
+	if err := stub.PutState(loanID, loanBytes); err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionEnd("put" + loanID)
+}
+
+func readLoan(t *testing.T, stub testStub, loanID string) loanInfo {
+	loan := loanInfo{}
+	if err := json.Unmarshal(stub.State[loanID], &loan); err != nil {
+		t.Fatal(err)
+	}
+	return loan
+}
+
+func TestUpdateLoanInfoDisbursement(t *testing.T) {
+
+	instruments := &recordingCC{}
+	stub := newTestStub(t, map[string]shim.Chaincode{"instrumentcc": instruments})
+	putLoan(t, stub, "loan1", loanInfo{
+		InstNum:          "inst1",
+		SellerBusinessID: "seller1",
+		SanctionAmt:      100000,
+		DueDate:          time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+		LoanStatus:       "sanctioned",
+		Currency:         "INR",
+	})
+
+	steps := []struct {
+		status string
+		from   string
+	}{
+		{"part disbursed", "sanctioned"},
+		{"disbursed", "part disbursed"},
+	}
+	for i, step := range steps {
+		txID := "disb" + string(rune('1'+i))
+		stub.MockTransactionStart(txID)
+		response := updateLoanInfo(stub, []string{"loan1", step.status, "disbursement"})
+		stub.MockTransactionEnd(txID)
+		if response.Status != shim.OK {
+			t.Fatalf("disbursing a %s loan: %s", step.from, response.Message)
+		}
+		if status := readLoan(t, stub, "loan1").LoanStatus; status != step.status {
+			t.Fatalf("disbursing a %s loan left it %s, want %s", step.from, status, step.status)
+		}
+	}
+	if len(instruments.Calls) != 2 || instruments.Calls[1][1] != "inst1,seller1,disbursed" {
+		t.Fatalf("instrument status calls %v", instruments.Calls)
+	}
+
+	stub.MockTransactionStart("disb3")
+	response := updateLoanInfo(stub, []string{"loan1", "disbursed", "disbursement"})
+	stub.MockTransactionEnd("disb3")
+	if response.Status == shim.OK {
+		t.Fatal("a disbursed loan was disbursed again")
+	}
+}
+
+func TestUpdateLoanInfoRepayment(t *testing.T) {
+
+	stub := newTestStub(t, nil)
+	putLoan(t, stub, "loan1", loanInfo{
+		SanctionAmt: 100000,
+		DueDate:     time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC),
+		LoanStatus:  "overdue",
+		Currency:    "INR",
+	})
+
+	for i, status := range []string{"part collected", "part collected", "collected"} {
+		txID := "rep" + string(rune('1'+i))
+		stub.MockTransactionStart(txID)
+		response := updateLoanInfo(stub, []string{"loan1", "repayment", status})
+		stub.MockTransactionEnd(txID)
+		if response.Status != shim.OK {
+			t.Fatalf("repayment %d: %s", i+1, response.Message)
+		}
+		if got := readLoan(t, stub, "loan1").LoanStatus; got != status {
+			t.Fatalf("repayment %d left the loan %s, want %s", i+1, got, status)
+		}
+	}
+
+	stub.MockTransactionStart("rep4")
+	response := updateLoanInfo(stub, []string{"loan1", "repayment", "collected"})
+	stub.MockTransactionEnd("rep4")
+	if response.Status == shim.OK {
+		t.Fatal("a collected loan was collected again")
+	}
+}
+
+func TestGetAccruedAfter(t *testing.T) {
+
+	stub := newTestStub(t, nil)
+	stub.MockTransactionStart("setup")
+	for i, loanID := range []string{"loan1", "loan1", "loan1", "loan2"} {
+		day := time.Date(2026, 7, 4+i, 0, 0, 0, 0, time.UTC)
+		accrualKey, _ := stub.CreateCompositeKey("LoanID~AccrualDate", []string{loanID, day.Format("2006-01-02")})
+		accrualBytes, _ := json.Marshal(accrualInfo{loanID, day, 10000, 12, 10 * int64(i+1)})
+		stub.PutState(accrualKey, accrualBytes)
+	}
+	stub.MockTransactionEnd("setup")
+
+	// loan1 accrued 10 on the 4th, 20 on the 5th and 30 on the 6th
+	response := getAccruedAfter(stub, []string{"loan1", "04/07/2026"})
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if string(response.Payload) != "50" {
+		t.Fatalf("interest accrued after the 4th %s, want 50", response.Payload)
+	}
+	response = getAccruedAfter(stub, []string{"loan1", "06/07/2026"})
+	if string(response.Payload) != "0" {
+		t.Fatalf("interest accrued after the last accrual %s, want 0", response.Payload)
+	}
+}
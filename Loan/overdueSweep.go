@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type overdueLoan struct {
+	LoanID         string
+	InstNum        string
+	PreviousStatus string
+	DueDate        time.Time
+}
+
+type skippedLoan struct {
+	LoanID string
+	Reason string
+}
+
+type overdueSummary struct {
+	AsOfDate time.Time
+	Overdue  []overdueLoan
+	Skipped  []skippedLoan
+}
+
+func markOverdue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> as of date (dd/mm/yyyy)
+		Every sanctioned or disbursed loan whose due date has passed is moved
+		to overdue along with its instrument, and what is left of its sanction
+		no longer uses the limits.
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in markOverdue(loan) (required:1) given:" + xLenStr)
+	}
+
+	asOfDate, err := time.Parse("02/01/2006", args[0])
+	if err != nil {
+		return shim.Error("Invalid as of date in markOverdue(loan):" + err.Error())
+	}
+
+	loansIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the loans (markOverdue):" + err.Error())
+	}
+	defer loansIterator.Close()
+
+	overdueStatus := map[string]bool{
+		"sanctioned":     true,
+		"part disbursed": true,
+		"disbursed":      true,
+	}
+
+	summary := overdueSummary{asOfDate, []overdueLoan{}, []skippedLoan{}}
+	for loansIterator.HasNext() {
+		loanData, err := loansIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the loans (markOverdue):" + err.Error())
+		}
+
+		loan := loanInfo{}
+		err = json.Unmarshal(loanData.Value, &loan)
+		if err != nil || loan.InstNum == "" {
+			continue
+		}
+		migrateLegacyLoan(&loan)
+		if !overdueStatus[loan.LoanStatus] || !loan.DueDate.Before(asOfDate) {
+			continue
+		}
+
+		//Calling instrument chaincode to update the status
+		argsList := []string{loan.InstNum, loan.SellerBusinessID, "overdue"}
+		argsListStr := strings.Join(argsList, ",")
+		chaincodeArgs := toChaincodeArgs("updateInsStatus", argsListStr)
+		response := stub.InvokeChaincode("instrumentcc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			summary.Skipped = append(summary.Skipped, skippedLoan{loanData.Key, response.Message})
+			continue
+		}
+
+		//Past the due date the undisbursed sanction lapses
+		err = lapseSanction(stub, loanData.Key, loan)
+		if err != nil {
+			return shim.Error("Sanction lapse (markOverdue) " + loanData.Key + ":" + err.Error())
+		}
+
+		previousStatus := loan.LoanStatus
+		loan.LoanStatus = "overdue"
+		loanBytes, _ := json.Marshal(loan)
+		err = stub.PutState(loanData.Key, loanBytes)
+		if err != nil {
+			return shim.Error("Error in loan status updation (markOverdue) " + err.Error())
+		}
+		err = updateLoanStatusIndex(stub, loanData.Key, previousStatus, loan.LoanStatus)
+		if err != nil {
+			return shim.Error("Error in loan status index updation (markOverdue) " + err.Error())
+		}
+		summary.Overdue = append(summary.Overdue, overdueLoan{loanData.Key, loan.InstNum, previousStatus, loan.DueDate})
+	}
+
+	if len(summary.Overdue) != 0 {
+		err = common.RecordSubmitter(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	summaryBytes, _ := json.Marshal(summary)
+	return shim.Success(summaryBytes)
+}
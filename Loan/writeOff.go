@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+func writeOffLoan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> TxnID
+		args[1] -> TxnType
+		args[2] -> TxnDate
+		args[3] -> LoanID
+		args[4] -> InsID
+		args[5] -> Amt (the outstanding principal)
+		args[6] -> FromID (BankID)
+		args[7] -> ToID
+		args[8] -> By
+		args[9] -> PprID
+
+		a. Debiting (Decreasing) Loan Disbursed Wallet
+		b. Debiting (Decreasing) Bank Asset Wallet
+		c. Debiting (Decreasing) Bank Provision Wallet with the provision held against the loan
+		d. Crediting (Increasing) Bank Write-off Wallet, recoveries are taken off it
+		e. Debiting (Decreasing) Bank Contra Wallet, mirroring the Loan Disbursed and Bank Provision Wallets
+	*/
+	args = strings.Split(args[0], ",")
+	if len(args) != 10 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in writeOffLoan(loan) (required:10) given:" + xLenStr)
+	}
+
+	txnDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Write off TxnDate " + err.Error())
+	}
+	amt, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return shim.Error("Write off Txn amt " + err.Error())
+	}
+
+	loanBytes, err := stub.GetState(args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if loanBytes == nil {
+		return shim.Error("No data exists on this loanID: " + args[3])
+	}
+	loan := loanInfo{}
+	err = json.Unmarshal(loanBytes, &loan)
+	if err != nil {
+		return shim.Error("error in unmarshiling loan: in writeOffLoan" + err.Error())
+	}
+	migrateLegacyLoan(&loan)
+
+	if loan.AssetClass != "npa" {
+		return shim.Error("Loan " + args[3] + " is " + loan.AssetClass + ", only NPA loans can be written off")
+	}
+	if loan.LoanStatus == "written off" {
+		return shim.Error("Loan " + args[3] + " is already written off")
+	}
+
+	principal, err := getWalletValue(stub, loan.LoanDisbursedWalletID)
+	if err != nil {
+		return shim.Error("Write off Loan Disbursed Wallet " + err.Error())
+	}
+	if principal <= 0 {
+		return shim.Error("Loan " + args[3] + " has no principal outstanding")
+	}
+	if amt != principal {
+		return shim.Error("Write off amount " + args[5] + " is not the outstanding principal " + strconv.FormatInt(principal, 10))
+	}
+
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		cAmt       int64
+		dAmt       int64
+		contra     bool
+	}{
+		{"1WO", "loancc", "", "disbursed", 0, principal, false},
+		{"2WO", "bankcc", args[6], "asset", 0, principal, false},
+		{"3WO", "bankcc", args[6], "provision", 0, loan.Provision, false},
+		{"4WO", "bankcc", args[6], "writeoff", principal, 0, false},
+		{"5WO", "bankcc", args[6], "contra", 0, principal + loan.Provision, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		if posting.cAmt == 0 && posting.dAmt == 0 {
+			continue
+		}
+		walletID := loan.LoanDisbursedWalletID
+		if posting.ccName == "bankcc" {
+			chaincodeArgs := toChaincodeArgs("getWalletID", posting.id, posting.walletType)
+			response := stub.InvokeChaincode("bankcc", chaincodeArgs, "myc")
+			if response.Status != shim.OK {
+				return shim.Error("Write off " + posting.walletType + " WalletID " + response.Message)
+			}
+			walletID = string(response.Payload)
+			if walletID == "" {
+				return shim.Error("Bank " + posting.id + " has no " + posting.walletType + " wallet, run addProvisionWallets")
+			}
+		}
+		row := loanRow(stub, posting.keyPrefix, args[3], loan, txnDate, "write off", posting.cAmt+posting.dAmt, args[8])
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "credit", Amt: posting.cAmt, Memo: true, Contra: posting.contra}, row)
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "debit", Amt: posting.dAmt, Memo: true, Contra: posting.contra}, row)
+	}
+	_, err = journal.Post(stub)
+	if err != nil {
+		return shim.Error("Write off Wallets " + err.Error())
+	}
+
+	//The written off principal no longer uses the program limit and the business limits
+	err = updateLimits(stub, args[3], loan, "write off", principal)
+	if err != nil {
+		return shim.Error("Write off limits " + err.Error())
+	}
+	err = lapseSanction(stub, args[3], loan)
+	if err != nil {
+		return shim.Error("Write off sanction lapse " + err.Error())
+	}
+
+	previousStatus := loan.LoanStatus
+	loan.LoanStatus = "written off"
+	loan.WrittenOff = principal
+	loan.Provision = 0
+	loanBytes, _ = json.Marshal(loan)
+	err = stub.PutState(args[3], loanBytes)
+	if err != nil {
+		return shim.Error("Error in loan updation " + err.Error())
+	}
+	err = updateLoanStatusIndex(stub, args[3], previousStatus, loan.LoanStatus)
+	if err != nil {
+		return shim.Error("Error in loan status index updation " + err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// updateLimits passes a change in the loan outstanding on to the program
+// utilization and the exposure of the exposure business and the buyer
+func updateLimits(stub shim.ChaincodeStubInterface, loanID string, loan loanInfo, event string, amt int64) error {
+
+	amtStr := strconv.FormatInt(amt, 10)
+	chaincodeArgs := toChaincodeArgs("updateUtilization", loan.ProgramID, event, amtStr, loan.Currency, loanID)
+	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+
+	businessIDs := []string{loan.ExposureBusinessID}
+	if loan.BuyerBusinessID != loan.ExposureBusinessID {
+		businessIDs = append(businessIDs, loan.BuyerBusinessID)
+	}
+	for _, businessID := range businessIDs {
+		chaincodeArgs = toChaincodeArgs("updateExposure", businessID, loan.ProgramID, event, amtStr, loan.Currency, loanID)
+		response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return errors.New(response.Message)
+		}
+	}
+	return nil
+}
+
+// lapseSanction releases the part of the sanction of a sanctioned or part
+// disbursed loan that can no longer be disbursed
+func lapseSanction(stub shim.ChaincodeStubInterface, loanID string, loan loanInfo) error {
+
+	if loan.LoanStatus != "sanctioned" && loan.LoanStatus != "part disbursed" {
+		return nil
+	}
+	disbursed, err := getWalletValue(stub, loan.LoanDisbursedWalletID)
+	if err != nil {
+		return err
+	}
+	if loan.SanctionAmt <= disbursed {
+		return nil
+	}
+	return updateLimits(stub, loanID, loan, "lapse", loan.SanctionAmt-disbursed)
+}
+
+func recoverWriteOff(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> TxnID
+		args[1] -> TxnType
+		args[2] -> TxnDate
+		args[3] -> LoanID
+		args[4] -> InsID
+		args[5] -> Amt
+		args[6] -> FromID (BusinessID paying)
+		args[7] -> ToID (BankID)
+		args[8] -> By
+		args[9] -> PprID
+
+		a. Debiting (Decreasing) Business Main Wallet
+		b. Crediting (Increasing) Bank Main Wallet
+		c. Debiting (Decreasing) Bank Write-off Wallet
+		    i. Loan Status is updated to Recovered when the written off principal is recovered in full
+		d. Debiting (Decreasing) Bank Contra Wallet, mirroring the Bank Write-off Wallet
+	*/
+	args = strings.Split(args[0], ",")
+	if len(args) != 10 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in recoverWriteOff(loan) (required:10) given:" + xLenStr)
+	}
+
+	txnDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Recovery TxnDate " + err.Error())
+	}
+	amt, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return shim.Error("Recovery Txn amt " + err.Error())
+	}
+	if amt <= 0 {
+		return shim.Error("Transaction Amount in Recovery is less than or equal to zero")
+	}
+
+	loanBytes, err := stub.GetState(args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if loanBytes == nil {
+		return shim.Error("No data exists on this loanID: " + args[3])
+	}
+	loan := loanInfo{}
+	err = json.Unmarshal(loanBytes, &loan)
+	if err != nil {
+		return shim.Error("error in unmarshiling loan: in recoverWriteOff" + err.Error())
+	}
+	migrateLegacyLoan(&loan)
+
+	if loan.LoanStatus != "written off" {
+		return shim.Error("Loan " + args[3] + " is not written off: " + loan.LoanStatus)
+	}
+	pending := loan.WrittenOff - loan.Recovered
+	if amt > pending {
+		return shim.Error("Recovery amount " + args[5] + " exceeds the " + strconv.FormatInt(pending, 10) + " pending on loan " + args[3])
+	}
+
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		cAmt       int64
+		dAmt       int64
+		memo       bool
+		contra     bool
+	}{
+		{"1WR", "businesscc", args[6], "main", 0, amt, false, false},
+		{"2WR", "bankcc", args[7], "main", amt, 0, false, false},
+		{"3WR", "bankcc", args[7], "writeoff", 0, amt, true, false},
+		{"4WR", "bankcc", args[7], "contra", 0, amt, true, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		chaincodeArgs := toChaincodeArgs("getWalletID", posting.id, posting.walletType)
+		response := stub.InvokeChaincode(posting.ccName, chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error("Recovery " + posting.walletType + " WalletID " + response.Message)
+		}
+		// Several recoveries can fall on the same day, the TxnID keeps their rows apart
+		row := loanRow(stub, posting.keyPrefix+args[0], args[3], loan, txnDate, "write off recovery", amt, args[8])
+		journal.Add(common.JournalLeg{WalletID: string(response.Payload), Type: "credit", Amt: posting.cAmt, Memo: posting.memo, Contra: posting.contra}, row)
+		journal.Add(common.JournalLeg{WalletID: string(response.Payload), Type: "debit", Amt: posting.dAmt, Memo: posting.memo, Contra: posting.contra}, row)
+	}
+	_, err = journal.Post(stub)
+	if err != nil {
+		return shim.Error("Recovery Wallets " + err.Error())
+	}
+
+	loan.Recovered += amt
+	previousStatus := loan.LoanStatus
+	if loan.Recovered == loan.WrittenOff {
+		loan.LoanStatus = "recovered"
+	}
+	loanBytes, _ = json.Marshal(loan)
+	err = stub.PutState(args[3], loanBytes)
+	if err != nil {
+		return shim.Error("Error in loan updation " + err.Error())
+	}
+	if previousStatus != loan.LoanStatus {
+		err = updateLoanStatusIndex(stub, args[3], previousStatus, loan.LoanStatus)
+		if err != nil {
+			return shim.Error("Error in loan status index updation " + err.Error())
+		}
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"createPPR":          {Roles: []string{"bank maker"}},
+	"seePPR":             {Roles: []string{"*"}},
+	"pprIDexists":        {Roles: []string{"*"}},
+	"discountPercentage": {Roles: []string{"*"}},
+	"updatePPR":          {Chaincodes: []string{"approvalcc"}},
+	"penalROI":           {Roles: []string{"*"}},
+	"getHistory":         {Roles: []string{"*"}},
+	"recordSanction":     {Chaincodes: []string{"approvalcc"}},
+	"reindexPPR":         {Roles: []string{"platform admin"}},
+	"getPPRTerms":        {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
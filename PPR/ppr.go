@@ -8,6 +8,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -24,6 +25,8 @@ type pprInfo struct {
 	StaleDays                         int     //[8]
 	RepaymentAcNo                     string  //[9]
 	RepaymentWalletID                 string  //will be taken from business Id
+	PenalROI                          float64 //set through updatePPR, overrides the program penal roi
+	Currency                          string  //currency of ProgramBusinessLimit
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -42,6 +45,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "createPPR" {
 		//Creates a new PPR Information
 		return createPPR(stub, args)
@@ -64,6 +72,21 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 			are updated
 		*/
 		return updatePPR(stub, args)
+	} else if function == "penalROI" {
+		//Returns the penal rate of interest for overdue loans
+		return penalROI(stub, args)
+	} else if function == "getHistory" {
+		//Returns every past version of the record with its submitter
+		return getHistory(stub, args)
+	} else if function == "recordSanction" {
+		//Checks a loan sanction against the PPR limit and roi and records it
+		return recordSanction(stub, args)
+	} else if function == "reindexPPR" {
+		//Writes the ProgramID~BusinessID~PprID index for the existing PPRs
+		return reindexPPR(stub, args)
+	} else if function == "getPPRTerms" {
+		//Returns the discount period and stale days for instrument eligibility
+		return getPPRTerms(stub, args)
 	}
 	return shim.Error("No function named " + function + " in PPRsssssss")
 }
@@ -145,10 +168,20 @@ func createPPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	}
 	repayWalletID := string(response.GetPayload())
 
-	ppr := pprInfo{args[1], args[2], relationshipLower, PBLimit, PBroi, PBDperiod, args[7], sDays, args[9], repayWalletID}
+	ppr := pprInfo{args[1], args[2], relationshipLower, PBLimit, PBroi, PBDperiod, args[7], sDays, args[9], repayWalletID, 0, "INR"}
 	pprBytes, err := json.Marshal(ppr)
 	err = stub.PutState(args[0], pprBytes)
 
+	//Loans find the PPR of their program and business through this index
+	err = putPairIndex(stub, args[0], ppr)
+	if err != nil {
+		return shim.Error("Unable to index pprID " + args[0] + ":" + err.Error())
+	}
+
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -169,16 +202,23 @@ func updatePPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	/*
 		args[0] -> pprID
 		args[1] -> Program Business Limit, Program Business ROI,
-				   Program Business Discount Percentage, Program Business Discount Period
+				   Program Business Discount Percentage, Program Business Discount Period,
+				   Program Business Penal ROI
 		args[2] -> values
 	*/
 	pprObject := pprInfo{}
 	pprBytes, err := stub.GetState(args[0])
 	if err != nil {
 		return shim.Error("updatePPR(PPR)" + err.Error())
+	} else if pprBytes == nil {
+		return shim.Error("No information on this pprID(updatePPR): " + args[0])
 	}
 
 	err = json.Unmarshal(pprBytes, &pprObject)
+	if err != nil {
+		return shim.Error("updatePPR(PPR)" + err.Error())
+	}
+	migrateLegacyPPR(&pprObject)
 	lowerStr := strings.ToLower(args[1])
 
 	if lowerStr == "program business limit" {
@@ -209,21 +249,102 @@ func updatePPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 			return shim.Error("updatePPR(PPR) Program Business Discount Period" + err.Error())
 		}
 		pprObject.ProgramBusinessDiscountPeriod = PBDperiod
+	} else if lowerStr == "program business penal roi" {
+		//Changing Program Business Penal ROI
+		penalRate, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return shim.Error("updatePPR(PPR) Program Business Penal ROI" + err.Error())
+		}
+		if penalRate < 0 {
+			return shim.Error("Invalid penal roi value: " + args[2])
+		}
+		pprObject.PenalROI = penalRate
+	} else {
+		return shim.Error("Invalid field for updatePPR: " + args[1])
+	}
+
+	pprBytes, _ = json.Marshal(pprObject)
+	err = stub.PutState(args[0], pprBytes)
+	if err != nil {
+		return shim.Error("updatePPR(PPR)" + err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 	return shim.Success(nil)
 
 }
 
+func penalROI(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> PprID
+		or
+		args[0] -> ProgramID
+		args[1] -> BusinessID
+	*/
+	if len(args) == 2 {
+		_, ppr, err := pprForPair(stub, args[0], args[1])
+		if err != nil {
+			return shim.Error("penalROI " + err.Error())
+		}
+		return shim.Success([]byte(strconv.FormatFloat(ppr.PenalROI, 'f', 4, 64)))
+	}
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in penalROI(PPR) (required:1 or 2) given:" + xLenStr)
+	}
+
+	pprObject := pprInfo{}
+	pprBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if pprBytes == nil {
+		return shim.Error("No information on this pprID(penalROI): " + args[0])
+	}
+
+	err = json.Unmarshal(pprBytes, &pprObject)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatFloat(pprObject.PenalROI, 'f', 4, 64)))
+}
+
 func discountPercentage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	prgrmBusPercentageIte, err := stub.GetStateByPartialCompositeKey("ProgramID~BusinessID~DiscountPercentage", []string{args[0], args[1]})
-	prgrmBusPercentageData, _ := prgrmBusPercentageIte.Next()
-	_, data, err := stub.SplitCompositeKey(prgrmBusPercentageData.Key)
+	/*
+		args[0] -> ProgramID
+		args[1] -> BusinessID
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in discountPercentage(PPR) (required:2) given:" + xLenStr)
+	}
+
+	_, ppr, err := pprForPair(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("discountPercentage " + err.Error())
+	}
+	return shim.Success([]byte(ppr.ProgramBusinessDiscountPercentage))
+}
+
+func getPPRTerms(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getPPRTerms(PPR) (required:1) given:" + xLenStr)
+	}
+
+	ppr, err := readPPR(stub, args[0])
 	if err != nil {
-		return shim.Error("Error spliting composite key ProgramID~BusinessID~DiscountPercentage (ppr):" + err.Error())
+		return shim.Error("getPPRTerms " + err.Error())
 	}
-	defer prgrmBusPercentageIte.Close()
-	return shim.Success([]byte(data[2]))
+
+	// ProgramID,BusinessID,DiscountPeriod,StaleDays
+	terms := ppr.ProgramID + "," + ppr.BusinessID + "," + strconv.Itoa(ppr.ProgramBusinessDiscountPeriod) + "," + strconv.Itoa(ppr.StaleDays)
+	return shim.Success([]byte(terms))
 }
 
 func seePPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -245,6 +366,14 @@ func seePPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 }
 
+// PPRs written before minor units carry the limit in rupees and no currency
+func migrateLegacyPPR(ppr *pprInfo) {
+	if ppr.Currency == "" {
+		ppr.ProgramBusinessLimit = ppr.ProgramBusinessLimit * 100
+		ppr.Currency = "INR"
+	}
+}
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+func putPairIndex(stub shim.ChaincodeStubInterface, pprID string, ppr pprInfo) error {
+
+	pairKey, err := stub.CreateCompositeKey("ProgramID~BusinessID~PprID", []string{ppr.ProgramID, ppr.BusinessID, pprID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(pairKey, []byte{0x00})
+}
+
+func readPPR(stub shim.ChaincodeStubInterface, pprID string) (pprInfo, error) {
+
+	ppr := pprInfo{}
+	pprBytes, err := stub.GetState(pprID)
+	if err != nil {
+		return ppr, err
+	} else if pprBytes == nil {
+		return ppr, errors.New("No information on this pprID: " + pprID)
+	}
+	err = json.Unmarshal(pprBytes, &ppr)
+	if err != nil {
+		return ppr, err
+	}
+	migrateLegacyPPR(&ppr)
+	return ppr, nil
+}
+
+// pprForPair returns the PPR of the business under the program
+func pprForPair(stub shim.ChaincodeStubInterface, programID string, businessID string) (string, pprInfo, error) {
+
+	pairIterator, err := stub.GetStateByPartialCompositeKey("ProgramID~BusinessID~PprID", []string{programID, businessID})
+	if err != nil {
+		return "", pprInfo{}, err
+	}
+	defer pairIterator.Close()
+
+	if !pairIterator.HasNext() {
+		return "", pprInfo{}, errors.New("No PPR for program " + programID + " and business " + businessID)
+	}
+	pairData, err := pairIterator.Next()
+	if err != nil {
+		return "", pprInfo{}, err
+	}
+	_, keyParts, err := stub.SplitCompositeKey(pairData.Key)
+	if err != nil {
+		return "", pprInfo{}, err
+	}
+	ppr, err := readPPR(stub, keyParts[2])
+	return keyParts[2], ppr, err
+}
+
+func recordSanction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> ProgramID
+		args[1] -> BusinessID
+		args[2] -> sanction amount in minor units
+		args[3] -> currency of the amount
+		args[4] -> loan ROI
+	*/
+	if len(args) != 5 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in recordSanction(PPR) (required:5) given:" + xLenStr)
+	}
+
+	pprID, ppr, err := pprForPair(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("recordSanction " + err.Error())
+	}
+
+	amt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid amount (recordSanction):" + err.Error())
+	}
+	if amt <= 0 {
+		return shim.Error("Sanction amount must be greater than zero: " + args[2])
+	}
+
+	// Loans in another currency count against the PPR limit at the rate of the sanction date
+	txDate, err := common.TxDate(stub)
+	if err != nil {
+		return shim.Error("recordSanction " + err.Error())
+	}
+	amt, err = common.ConvertAmount(stub, amt, args[3], ppr.Currency, txDate)
+	if err != nil {
+		return shim.Error("recordSanction " + err.Error())
+	}
+	roi, err := strconv.ParseFloat(args[4], 64)
+	if err != nil {
+		return shim.Error("Invalid roi (recordSanction):" + err.Error())
+	}
+	if roi < ppr.ProgramBusinessROI {
+		return shim.Error(fmt.Sprintf("Loan roi %.4f is below the roi %.4f of PPR %s", roi, ppr.ProgramBusinessROI, pprID))
+	}
+
+	sanctionedKey, err := stub.CreateCompositeKey("Sanctioned~PprID", []string{pprID})
+	if err != nil {
+		return shim.Error("Unable to create composite key Sanctioned~PprID:" + err.Error())
+	}
+	sanctionedBytes, err := stub.GetState(sanctionedKey)
+	if err != nil {
+		return shim.Error("recordSanction " + err.Error())
+	}
+	var sanctioned int64
+	if sanctionedBytes != nil {
+		sanctioned, err = strconv.ParseInt(string(sanctionedBytes), 10, 64)
+		if err != nil {
+			return shim.Error("Unable to parse the sanctioned amount (recordSanction):" + err.Error())
+		}
+	}
+
+	if sanctioned+amt > ppr.ProgramBusinessLimit {
+		return shim.Error(fmt.Sprintf("Sanction of %d breaches the limit %d of PPR %s, already sanctioned: %d", amt, ppr.ProgramBusinessLimit, pprID, sanctioned))
+	}
+
+	err = stub.PutState(sanctionedKey, []byte(strconv.FormatInt(sanctioned+amt, 10)))
+	if err != nil {
+		return shim.Error("Error in sanctioned amount updation " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func reindexPPR(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		Writes the ProgramID~BusinessID~PprID index for the PPRs created before it
+	*/
+	pprIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the PPRs (reindexPPR):" + err.Error())
+	}
+	defer pprIterator.Close()
+
+	count := 0
+	for pprIterator.HasNext() {
+		pprData, err := pprIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the PPRs (reindexPPR):" + err.Error())
+		}
+		ppr := pprInfo{}
+		err = json.Unmarshal(pprData.Value, &ppr)
+		if err != nil || ppr.ProgramID == "" {
+			continue
+		}
+		err = putPairIndex(stub, pprData.Key, ppr)
+		if err != nil {
+			return shim.Error("Unable to index pprID " + pprData.Key + ":" + err.Error())
+		}
+		count++
+	}
+	return shim.Success([]byte(strconv.Itoa(count)))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"writeProgram":          {Roles: []string{"platform admin", "bank maker"}},
+	"getProgram":            {Roles: []string{"*"}},
+	"programIDexists":       {Roles: []string{"*"}},
+	"updateProgramInfo":     {Chaincodes: []string{"approvalcc"}},
+	"penalROI":              {Roles: []string{"*"}},
+	"getRollConvention":     {Roles: []string{"*"}},
+	"getProgramTerms":       {Roles: []string{"*"}},
+	"getAllocationOrder":    {Roles: []string{"*"}},
+	"updateUtilization":     {Chaincodes: []string{"loancc", "approvalcc", "txncc", "disbursementcc", "repaycc"}},
+	"getProgramUtilization": {Roles: []string{"*"}},
+	"getHistory":            {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Repayments go to the penal interest due, the loan charges, the accrued
+// interest and the principal in this order unless the program sets its own.
+const defaultAllocationOrder = "penal;charges;interest;principal"
+
+// parseAllocationOrder accepts every bucket exactly once, in any order
+func parseAllocationOrder(orderStr string) (string, error) {
+
+	buckets := map[string]bool{
+		"penal":     false,
+		"charges":   false,
+		"interest":  false,
+		"principal": false,
+	}
+
+	order := []string{}
+	for _, bucket := range strings.Split(orderStr, ";") {
+		bucketLower := strings.ToLower(strings.TrimSpace(bucket))
+		seen, ok := buckets[bucketLower]
+		if !ok {
+			return "", errors.New("Invalid allocation bucket " + bucket)
+		} else if seen {
+			return "", errors.New("Allocation bucket " + bucket + " is repeated")
+		}
+		buckets[bucketLower] = true
+		order = append(order, bucketLower)
+	}
+	if len(order) != len(buckets) {
+		return "", errors.New("Allocation order must have all of " + defaultAllocationOrder)
+	}
+	return strings.Join(order, ";"), nil
+}
+
+func getAllocationOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getAllocationOrder(program) (required:1) given:" + xLenStr)
+	}
+
+	pInfo, err := readProgram(stub, args[0])
+	if err != nil {
+		return shim.Error("getAllocationOrder " + err.Error())
+	}
+
+	// Programs written before allocation orders use the default
+	order := pInfo.AllocationOrder
+	if order == "" {
+		order = defaultAllocationOrder
+	}
+	return shim.Success([]byte(order))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -20,7 +21,7 @@ type programInfo struct {
 	ProgramType        string    //[3]
 	ProgramStartDate   time.Time //auto generated as created
 	ProgramEndDate     time.Time //[4]
-	ProgramLimit       int64     //[5]
+	ProgramLimit       int64     //[5]//minor units
 	ProgramROI         int64     //[6]
 	ProgramExposure    string    //[7]
 	DiscountPercentage int64     //[8]
@@ -29,8 +30,19 @@ type programInfo struct {
 	SanctionDate       time.Time //auto generated as created
 	RepaymentAcNum     string    //[11]
 	RepaymentWalletID  string    //taken from program anchors business id
+	PenalROI           float64   //set through updateProgramInfo
+	CalendarID         string    //holiday calendar for due dates, set through updateProgramInfo
+	RollConvention     string    //following, modified following or preceding
+	Currency           string    //currency of ProgramLimit
+	AllocationOrder    string    //repayment buckets separated by ";", set through updateProgramInfo
+	MaxExtensionDays   int64     //longest due date extension of a loan, 0 for up to the ProgramEndDate
+	MinTenorPercent    int64     //shortest instrument tenor as a percentage of the discount period, 0 for defaultMinTenorPercent
 }
 
+// Instruments of programs with no MinTenorPercent have to run for at least
+// this share of the discount period
+const defaultMinTenorPercent = 25
+
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Success(nil)
 }
@@ -38,6 +50,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "writeProgram" {
 		//Creates a new Program Information
 		return writeProgram(stub, args)
@@ -53,6 +70,27 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 			Discount Percentage,Discount Period and Program end date if required
 		*/
 		return updateProgramInfo(stub, args)
+	} else if function == "penalROI" {
+		//Returns the penal rate of interest for overdue loans
+		return penalROI(stub, args)
+	} else if function == "getRollConvention" {
+		//Returns the calendar and the roll convention for due dates
+		return getRollConvention(stub, args)
+	} else if function == "getProgramTerms" {
+		//Returns the validity, the discount period and the extension limit of the program
+		return getProgramTerms(stub, args)
+	} else if function == "getAllocationOrder" {
+		//Returns the order in which repayments are allocated to the loan buckets
+		return getAllocationOrder(stub, args)
+	} else if function == "getHistory" {
+		//Returns every past version of the record with its submitter
+		return getHistory(stub, args)
+	} else if function == "updateUtilization" {
+		//Moves the program utilization on sanction, disbursement and repayment
+		return updateUtilization(stub, args)
+	} else if function == "getProgramUtilization" {
+		//Returns the limit, utilized and available amounts of the program
+		return getProgramUtilization(stub, args)
 	}
 	return shim.Error("No function named " + function + " in Programsssssss")
 }
@@ -141,9 +179,16 @@ func writeProgram(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error(response.Message)
 	}
 	repayWalletID := string(response.GetPayload())
-	pInfo := programInfo{args[1], args[2], pTypeLower, pSDate, pEDate, pLimit, pROI, pExposureLower, dPercentage, dPeriod, args[10], sDate, args[11], repayWalletID}
+	pInfo := programInfo{args[1], args[2], pTypeLower, pSDate, pEDate, pLimit, pROI, pExposureLower, dPercentage, dPeriod, args[10], sDate, args[11], repayWalletID, 0, "", "following", "INR", defaultAllocationOrder, 0, defaultMinTenorPercent}
 	programInfoBytes, _ := json.Marshal(pInfo)
 	err = stub.PutState(args[0], programInfoBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.RecordSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -159,9 +204,14 @@ func updateProgramInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 
 	/*
 		args[0] -> ProgramID
-		args[1] -> Program Limit, Program ROI, Discount Percentage,Discount Period and Program End Date
+		args[1] -> Program Limit, Program ROI, Discount Percentage,Discount Period, Program End Date, Penal ROI,
+				   Calendar ID and Roll Convention
 		args[2] -> values
 	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in updateProgramInfo(program) (required:3) given:" + xLenStr)
+	}
 
 	pInfo := programInfo{}
 	pInfoBytes, err := stub.GetState(args[0])
@@ -176,6 +226,7 @@ func updateProgramInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	migrateLegacyProgram(&pInfo)
 
 	lowerStr := strings.ToLower(args[1])
 
@@ -185,25 +236,155 @@ func updateProgramInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respo
 			return shim.Error("updateProgramInfo updating programEndDate" + err.Error())
 		}
 		pInfo.ProgramEndDate = pEDate
+	} else if lowerStr == "penal roi" {
+		penalRate, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return shim.Error("updateProgramInfo updating penal roi" + err.Error())
+		}
+		if penalRate < 0 {
+			return shim.Error("Invalid penal roi value: " + args[2])
+		}
+		pInfo.PenalROI = penalRate
+	} else if lowerStr == "calendar id" {
+		// An empty calendar id goes back to the default calendar
+		if args[2] != "" {
+			chaincodeArgs := toChaincodeArgs("getCalendarInfo", args[2])
+			response := stub.InvokeChaincode("calendarcc", chaincodeArgs, "myc")
+			if response.Status != shim.OK {
+				return shim.Error("Invalid calendar id " + args[2] + ": " + response.Message)
+			}
+		}
+		pInfo.CalendarID = args[2]
+	} else if lowerStr == "roll convention" {
+		rollConventions := map[string]bool{
+			"following":          true,
+			"modified following": true,
+			"preceding":          true,
+		}
+		convention := strings.ToLower(args[2])
+		if !rollConventions[convention] {
+			return shim.Error("Invalid roll convention " + args[2])
+		}
+		pInfo.RollConvention = convention
+	} else if lowerStr == "allocation order" {
+		order, err := parseAllocationOrder(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		pInfo.AllocationOrder = order
+	} else {
+		value, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return shim.Error("value (updateProgramInfo):" + err.Error())
+		}
+
+		if lowerStr == "program limit" {
+			pInfo.ProgramLimit = value
+		} else if lowerStr == "program roi" {
+			pInfo.ProgramROI = value
+		} else if lowerStr == "discount percentage" {
+			pInfo.DiscountPercentage = value
+		} else if lowerStr == "discount period" {
+			pInfo.DiscountPeriod = value
+		} else if lowerStr == "max extension days" {
+			if value < 0 {
+				return shim.Error("Invalid max extension days: " + args[2])
+			}
+			pInfo.MaxExtensionDays = value
+		} else if lowerStr == "min tenor percent" {
+			if value < 0 || value > 100 {
+				return shim.Error("Invalid min tenor percent: " + args[2])
+			}
+			pInfo.MinTenorPercent = value
+		} else {
+			return shim.Error("Invalid field for updateProgramInfo: " + args[1])
+		}
 	}
 
-	value, err := strconv.ParseInt(args[2], 10, 64)
+	pInfoBytes, _ = json.Marshal(pInfo)
+	err = stub.PutState(args[0], pInfoBytes)
+	if err != nil {
+		return shim.Error("Error in program updation " + err.Error())
+	}
+	err = common.RecordSubmitter(stub)
 	if err != nil {
-		return shim.Error("value (updateProgramInfo):" + err.Error())
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("Program info updation successful"))
+}
+func penalROI(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in penalROI(program) (required:1) given:" + xLenStr)
 	}
 
-	if lowerStr == "program limit" {
-		pInfo.ProgramLimit = value
-	} else if lowerStr == "program roi" {
-		pInfo.ProgramROI = value
-	} else if lowerStr == "discount percentage" {
-		pInfo.DiscountPercentage = value
-	} else if lowerStr == "discount period" {
-		pInfo.DiscountPeriod = value
+	pInfo := programInfo{}
+	pInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if pInfoBytes == nil {
+		return shim.Error("No information on this programID(penalROI): " + args[0])
 	}
 
-	return shim.Success([]byte("Program info updation successful"))
+	err = json.Unmarshal(pInfoBytes, &pInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatFloat(pInfo.PenalROI, 'f', 4, 64)))
 }
+
+func getRollConvention(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getRollConvention(program) (required:1) given:" + xLenStr)
+	}
+
+	pInfo := programInfo{}
+	pInfoBytes, err := stub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if pInfoBytes == nil {
+		return shim.Error("No information on this programID(getRollConvention): " + args[0])
+	}
+
+	err = json.Unmarshal(pInfoBytes, &pInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Programs written before roll conventions keep rolling forward
+	convention := pInfo.RollConvention
+	if convention == "" {
+		convention = "following"
+	}
+	return shim.Success([]byte(pInfo.CalendarID + "," + convention))
+}
+
+func getProgramTerms(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getProgramTerms(program) (required:1) given:" + xLenStr)
+	}
+
+	pInfo, err := readProgram(stub, args[0])
+	if err != nil {
+		return shim.Error("getProgramTerms " + err.Error())
+	}
+
+	minTenorPercent := pInfo.MinTenorPercent
+	if minTenorPercent == 0 {
+		minTenorPercent = defaultMinTenorPercent
+	}
+
+	// ProgramStartDate,ProgramEndDate,DiscountPeriod,MaxExtensionDays,MinTenorPercent
+	terms := pInfo.ProgramStartDate.Format("02/01/2006") + "," + pInfo.ProgramEndDate.Format("02/01/2006") + "," + strconv.FormatInt(pInfo.DiscountPeriod, 10) + "," + strconv.FormatInt(pInfo.MaxExtensionDays, 10) + "," + strconv.FormatInt(minTenorPercent, 10)
+	return shim.Success([]byte(terms))
+}
+
 func getProgram(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	if len(args) != 1 {
@@ -224,12 +405,22 @@ func getProgram(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	migrateLegacyProgram(&pInfo)
 
 	printProgramInfo := fmt.Sprintf("%+v", pInfo)
 
 	return shim.Success([]byte(printProgramInfo))
 
 }
+
+// Programs written before minor units carry the limit in rupees and no currency
+func migrateLegacyProgram(pInfo *programInfo) {
+	if pInfo.Currency == "" {
+		pInfo.ProgramLimit = pInfo.ProgramLimit * 100
+		pInfo.Currency = "INR"
+	}
+}
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type utilizationReport struct {
+	ProgramID    string
+	Currency     string
+	ProgramLimit int64
+	Sanctioned   int64
+	Disbursed    int64
+	Outstanding  int64
+	Utilized     int64
+	Available    int64
+}
+
+func readProgram(stub shim.ChaincodeStubInterface, programID string) (programInfo, error) {
+
+	pInfo := programInfo{}
+	pInfoBytes, err := stub.GetState(programID)
+	if err != nil {
+		return pInfo, err
+	} else if pInfoBytes == nil {
+		return pInfo, errors.New("No information on this programID: " + programID)
+	}
+	err = json.Unmarshal(pInfoBytes, &pInfo)
+	if err != nil {
+		return pInfo, err
+	}
+	migrateLegacyProgram(&pInfo)
+	return pInfo, nil
+}
+
+// readUtilization returns the utilization of the program in minor units of the program currency
+func readUtilization(stub shim.ChaincodeStubInterface, programID string) (string, common.Utilization, error) {
+
+	utilization := common.Utilization{}
+	utilizationKey, err := stub.CreateCompositeKey("Utilization~ProgramID", []string{programID})
+	if err != nil {
+		return "", utilization, err
+	}
+	utilizationBytes, err := stub.GetState(utilizationKey)
+	if err != nil {
+		return utilizationKey, utilization, err
+	}
+	if utilizationBytes != nil {
+		err = json.Unmarshal(utilizationBytes, &utilization)
+	}
+	return utilizationKey, utilization, err
+}
+
+func updateUtilization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> ProgramID
+		args[1] -> event (sanction, disbursement, repayment, write off or lapse)
+		args[2] -> amount in minor units
+		args[3] -> currency of the amount
+		args[4] -> LoanID
+	*/
+	if len(args) != 5 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in updateUtilization(program) (required:5) given:" + xLenStr)
+	}
+
+	pInfo, err := readProgram(stub, args[0])
+	if err != nil {
+		return shim.Error("updateUtilization " + err.Error())
+	}
+
+	amt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid amount (updateUtilization):" + err.Error())
+	}
+	if amt <= 0 {
+		return shim.Error("Utilization amount must be greater than zero: " + args[2])
+	}
+
+	// Loans in another currency use the limit at the rate of their sanction
+	loanKey, err := stub.CreateCompositeKey("Utilization~ProgramID~LoanID", []string{args[0], args[4]})
+	if err != nil {
+		return shim.Error("Unable to create composite key Utilization~ProgramID~LoanID:" + err.Error())
+	}
+	move, err := common.ApplyLoanEvent(stub, loanKey, args[1], amt, args[3], pInfo.Currency)
+	if err != nil {
+		return shim.Error("updateUtilization " + err.Error())
+	}
+
+	utilizationKey, utilization, err := readUtilization(stub, args[0])
+	if err != nil {
+		return shim.Error("updateUtilization " + err.Error())
+	}
+
+	if args[1] == "sanction" && utilization.Utilized()+move.Sanctioned > pInfo.ProgramLimit {
+		return shim.Error(fmt.Sprintf("Sanction of %d breaches the program limit %d of %s, available: %d", move.Sanctioned, pInfo.ProgramLimit, args[0], pInfo.ProgramLimit-utilization.Utilized()))
+	}
+	utilization.Add(move)
+
+	utilizationBytes, _ := json.Marshal(utilization)
+	err = stub.PutState(utilizationKey, utilizationBytes)
+	if err != nil {
+		return shim.Error("Error in utilization updation " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getProgramUtilization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getProgramUtilization(program) (required:1) given:" + xLenStr)
+	}
+
+	pInfo, err := readProgram(stub, args[0])
+	if err != nil {
+		return shim.Error("getProgramUtilization " + err.Error())
+	}
+	_, utilization, err := readUtilization(stub, args[0])
+	if err != nil {
+		return shim.Error("getProgramUtilization " + err.Error())
+	}
+
+	utilized := utilization.Utilized()
+	report := utilizationReport{args[0], pInfo.Currency, pInfo.ProgramLimit, utilization.Sanctioned, utilization.Disbursed, utilization.Outstanding, utilized, pInfo.ProgramLimit - utilized}
+	reportBytes, _ := json.Marshal(report)
+	return shim.Success(reportBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// fakeFXRate answers getFXRate with whatever rate is set
+type fakeFXRate struct {
+	rate string
+}
+
+func (f *fakeFXRate) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (f *fakeFXRate) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success([]byte(f.rate))
+}
+
+func TestUpdateUtilizationAtSanctionRate(t *testing.T) {
+
+	fx := &fakeFXRate{"80.5"}
+	stub := shim.NewMockStub("programcc", new(chainCode))
+	stub.MockPeerChaincode("fxratecc/myc", shim.NewMockStub("fxratecc", fx))
+	stub.MockTransactionStart("setup")
+	pInfoBytes, _ := json.Marshal(programInfo{ProgramLimit: 10000000, Currency: "INR"})
+	stub.PutState("prog1", pInfoBytes)
+	stub.MockTransactionEnd("setup")
+
+	// A USD loan is sanctioned at 80.5, then the rate moves on every event
+	events := []struct {
+		event, amt, rate string
+	}{
+		{"sanction", "1001", "80.5"},
+		{"disbursement", "333", "91.3"},
+		{"disbursement", "333", "77.7"},
+		{"repayment", "333", "85.1"},
+		{"repayment", "333", "60.9"},
+		{"lapse", "335", "99.9"},
+	}
+	for _, e := range events {
+		fx.rate = e.rate
+		stub.MockTransactionStart(e.event)
+		response := updateUtilization(stub, []string{"prog1", e.event, e.amt, "USD", "loan1"})
+		stub.MockTransactionEnd(e.event)
+		if response.Status != shim.OK {
+			t.Fatalf("%s: %s", e.event, response.Message)
+		}
+	}
+
+	_, utilization, err := readUtilization(stub, "prog1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utilization.Sanctioned != 53613 || utilization.Disbursed != 53613 || utilization.Outstanding != 0 || utilization.Utilized() != 0 {
+		t.Fatalf("utilization left after the loan is repaid and lapsed %+v", utilization)
+	}
+
+	// A sanction over the limit is refused
+	fx.rate = "1"
+	stub.MockTransactionStart("breach")
+	response := updateUtilization(stub, []string{"prog1", "sanction", "10000001", "INR", "loan2"})
+	stub.MockTransactionEnd("breach")
+	if response.Status == shim.OK {
+		t.Fatal("sanction over the program limit accepted")
+	}
+}
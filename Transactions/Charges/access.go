@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"newChargesInfo": {Chaincodes: []string{"txncc"}},
+	"setGSTRate":     {Roles: []string{"platform admin"}},
+	"getGSTRate":     {Roles: []string{"*"}},
+	"getTaxInvoices": {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
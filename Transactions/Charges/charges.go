@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+type chainCode struct {
+}
+
+func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if function == "newChargesInfo" {
+		return newChargesInfo(stub, args)
+	} else if function == "setGSTRate" {
+		//Sets the GST rate levied on a charge type
+		return setGSTRate(stub, args)
+	} else if function == "getGSTRate" {
+		//Returns the GST rate levied on a charge type
+		return getGSTRate(stub, args)
+	} else if function == "getTaxInvoices" {
+		//Returns the tax invoices raised on a business in a period
+		return getTaxInvoices(stub, args)
+	}
+	return shim.Error("no function named " + function + " found in Charges")
+}
+
+func newChargesInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) == 1 {
+		args = strings.Split(args[0], ",")
+	}
+	if len(args) != 10 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in newChargesInfo(charges) (required:10) given:" + xLenStr)
+	}
+
+	/*
+	 *TxnType string    //args[1]  charges / cersai charges / factor regn charges
+	 *TxnDate time.Time //args[2]
+	 *LoanID  string    //args[3]
+	 *InsID   string    //args[4]
+	 *Amt     int64     //args[5]  fee before GST
+	 *FromID  string    //args[6]  Business
+	 *ToID    string    //args[7]  Bank
+	 *By      string    //args[8]
+	 *PprID   string    //args[9]
+	 */
+
+	///////////////////////////////////////////////////////////////////////////////////////////////////
+	// 				UPDATING WALLETS																///
+	///////////////////////////////////////////////////////////////////////////////////////////////////
+	// GST is levied on the fee as CGST and SGST when the bank and the business are registered in the
+	// same state, as IGST otherwise. The business pays the fee along with the GST.
+	/*
+			    a. Debiting (decreasing) Business Wallet
+		            i. Fee + GST
+		        b. Crediting (Increasing) Bank Wallet
+		            i. Fee + GST
+		        c. Crediting (Increasing) Bank Charges Wallet
+		            i. Fee
+		        d. Crediting (Increasing) Bank CGST, SGST or IGST Wallet
+		            i. the GST component
+		        e. A tax invoice is raised on the business
+	*/
+
+	chargeType := strings.ToLower(args[1])
+	if !chargeTypes[chargeType] {
+		return shim.Error("Invalid charge type " + args[1])
+	}
+	fee, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return shim.Error("Charges Txn amt " + err.Error())
+	}
+	if fee <= 0 {
+		return shim.Error("Transaction Amount in Charges is less than or equal to zero")
+	}
+	txnDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Charges TxnDate " + err.Error())
+	}
+
+	//####################################################################################################################
+	//Working out the GST from the state codes of the bank and the business
+	//####################################################################################################################
+
+	chaincodeArgs := toChaincodeArgs("getStateCode", args[7])
+	response := stub.InvokeChaincode("bankcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error("Charges bank state code " + response.Message)
+	}
+	bankState := string(response.Payload)
+
+	chaincodeArgs = toChaincodeArgs("getBusinessInfo", args[6])
+	response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error("Charges business " + response.Message)
+	}
+	business := struct {
+		StateCode string
+	}{}
+	err = json.Unmarshal(response.Payload, &business)
+	if err != nil {
+		return shim.Error("Charges unable to parse the business " + err.Error())
+	}
+	if business.StateCode == "" {
+		return shim.Error("Business " + args[6] + " has no GST state code")
+	}
+
+	rate, err := readGSTRate(stub, chargeType)
+	if err != nil {
+		return shim.Error("Charges " + err.Error())
+	}
+	cgst, sgst, igst := splitGST(fee, rate, bankState, business.StateCode)
+	total := fee + cgst + sgst + igst
+
+	//#####################################################################################################################
+	//Posting the main wallets and the bank fee and GST wallets as one journal, the bank contra
+	//wallet mirroring the fee and GST wallets
+	//####################################################################################################################
+
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		legType    string
+		amt        int64
+		memo       bool
+	}{
+		{"1chg", "businesscc", args[6], "main", "debit", total, false},
+		{"2chg", "bankcc", args[7], "main", "credit", total, false},
+		{"3chg", "bankcc", args[7], "charges", "credit", fee, true},
+		{"4chg", "bankcc", args[7], "cgst", "credit", cgst, true},
+		{"5chg", "bankcc", args[7], "sgst", "credit", sgst, true},
+		{"6chg", "bankcc", args[7], "igst", "credit", igst, true},
+		{"7chg", "bankcc", args[7], "contra", "credit", total, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		if posting.amt == 0 {
+			continue
+		}
+		walletID, err := getWalletID(stub, posting.ccName, posting.id, posting.walletType)
+		if err != nil {
+			return shim.Error("Charges " + posting.walletType + " WalletID " + err.Error())
+		}
+		if walletID == "" && posting.walletType == "contra" {
+			return shim.Error("Bank " + args[7] + " has no contra wallet, run addContraWallet")
+		} else if walletID == "" {
+			return shim.Error("Bank " + args[7] + " has no " + posting.walletType + " wallet, run registerGST")
+		}
+		// The TxnID keeps the Txn_Bal_Ledger rows of charges on the same loan apart
+		leg := common.JournalLeg{WalletID: walletID, Type: posting.legType, Amt: posting.amt, Memo: posting.memo, Contra: posting.walletType == "contra"}
+		journal.Add(leg, common.ArgsRow(posting.keyPrefix+args[0], args))
+	}
+	_, err = journal.Post(stub)
+	if err != nil {
+		return shim.Error("Charges Wallets " + err.Error())
+	}
+
+	//####################################################################################################################
+	//Raising the tax invoice
+	//####################################################################################################################
+
+	invoiceNo, err := nextInvoiceNo(stub, args[7], txnDate)
+	if err != nil {
+		return shim.Error("Charges invoice number " + err.Error())
+	}
+	invoice := taxInvoice{invoiceNo, args[0], chargeType, txnDate, args[7], bankState, args[6], business.StateCode, args[3], args[4], fee, rate, cgst, sgst, igst, total}
+	err = putInvoice(stub, invoice)
+	if err != nil {
+		return shim.Error("Charges tax invoice " + err.Error())
+	}
+
+	//####################################################################################################################
+
+	invoiceBytes, _ := json.Marshal(invoice)
+	return shim.Success(invoiceBytes)
+}
+
+func getWalletID(stub shim.ChaincodeStubInterface, ccName string, id string, walletType string) (string, error) {
+
+	chaincodeArgs := toChaincodeArgs("getWalletID", id, walletType)
+	response := stub.InvokeChaincode(ccName, chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return "0", errors.New(response.Message)
+	}
+	walletID := string(response.GetPayload())
+	return walletID, nil
+
+}
+
+func toChaincodeArgs(args ...string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i, arg := range args {
+		bargs[i] = []byte(arg)
+	}
+	return bargs
+}
+
+func main() {
+	err := shim.Start(new(chainCode))
+	if err != nil {
+		fmt.Println("Unable to start Charges chaincode:", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// GST on financial services unless a rate is set for the charge type
+const defaultGSTRate = 18
+
+var chargeTypes = map[string]bool{
+	"charges":             true,
+	"cersai charges":      true,
+	"factor regn charges": true,
+}
+
+// splitGST levies CGST and SGST at half the rate each on a supply within the
+// state of the bank, and IGST at the full rate on a supply to another state
+func splitGST(fee int64, rate float64, bankState string, businessState string) (int64, int64, int64) {
+
+	if bankState == businessState {
+		half := int64(math.Round(float64(fee) * rate / 200))
+		return half, half, 0
+	}
+	return 0, 0, int64(math.Round(float64(fee) * rate / 100))
+}
+
+func readGSTRate(stub shim.ChaincodeStubInterface, chargeType string) (float64, error) {
+
+	rateKey, err := stub.CreateCompositeKey("GSTRate~ChargeType", []string{chargeType})
+	if err != nil {
+		return 0, errors.New("Unable to create composite key GSTRate~ChargeType:" + err.Error())
+	}
+	rateBytes, err := stub.GetState(rateKey)
+	if err != nil {
+		return 0, err
+	} else if rateBytes == nil {
+		return defaultGSTRate, nil
+	}
+	return strconv.ParseFloat(string(rateBytes), 64)
+}
+
+func setGSTRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> charge type
+		args[1] -> GST rate in percent
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in setGSTRate(charges) (required:2) given:" + xLenStr)
+	}
+
+	chargeType := strings.ToLower(args[0])
+	if !chargeTypes[chargeType] {
+		return shim.Error("Invalid charge type " + args[0])
+	}
+	rate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return shim.Error("Invalid GST rate in setGSTRate:" + err.Error())
+	}
+	if rate < 0 || rate > 100 {
+		return shim.Error("GST rate " + args[1] + " is not between 0 and 100")
+	}
+
+	rateKey, err := stub.CreateCompositeKey("GSTRate~ChargeType", []string{chargeType})
+	if err != nil {
+		return shim.Error("Unable to create composite key GSTRate~ChargeType:" + err.Error())
+	}
+	err = stub.PutState(rateKey, []byte(strconv.FormatFloat(rate, 'f', -1, 64)))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getGSTRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getGSTRate(charges) (required:1) given:" + xLenStr)
+	}
+
+	chargeType := strings.ToLower(args[0])
+	if !chargeTypes[chargeType] {
+		return shim.Error("Invalid charge type " + args[0])
+	}
+	rate, err := readGSTRate(stub, chargeType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.FormatFloat(rate, 'f', -1, 64)))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// Tax invoice raised by the bank on the business for a charge, amounts in minor units
+type taxInvoice struct {
+	InvoiceNo         string //BankID/financial year/serial
+	TxnID             string
+	ChargeType        string
+	InvoiceDate       time.Time
+	BankID            string
+	BankStateCode     string
+	BusinessID        string
+	BusinessStateCode string
+	LoanID            string
+	InsID             string
+	Fee               int64
+	GSTRate           float64
+	CGST              int64
+	SGST              int64
+	IGST              int64
+	Total             int64 //Fee and the GST
+}
+
+type invoiceReport struct {
+	BusinessID string
+	FromDate   time.Time
+	ToDate     time.Time
+	Fee        int64
+	CGST       int64
+	SGST       int64
+	IGST       int64
+	Total      int64
+	Invoices   []taxInvoice
+}
+
+// nextInvoiceNo numbers the invoices of a bank serially within a financial year
+func nextInvoiceNo(stub shim.ChaincodeStubInterface, bankID string, invoiceDate time.Time) (string, error) {
+
+	fy := common.FinancialYear(invoiceDate)
+	seqKey, err := stub.CreateCompositeKey("InvoiceSeq~BankID~FinancialYear", []string{bankID, fy})
+	if err != nil {
+		return "", errors.New("Unable to create composite key InvoiceSeq~BankID~FinancialYear:" + err.Error())
+	}
+	seqBytes, err := stub.GetState(seqKey)
+	if err != nil {
+		return "", err
+	}
+	seq := 0
+	if seqBytes != nil {
+		seq, err = strconv.Atoi(string(seqBytes))
+		if err != nil {
+			return "", err
+		}
+	}
+	seq++
+	err = stub.PutState(seqKey, []byte(strconv.Itoa(seq)))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%06d", bankID, fy, seq), nil
+}
+
+func putInvoice(stub shim.ChaincodeStubInterface, invoice taxInvoice) error {
+
+	invoiceKey, err := stub.CreateCompositeKey("BusinessID~InvoiceDate~InvoiceNo", []string{invoice.BusinessID, invoice.InvoiceDate.Format("2006-01-02"), invoice.InvoiceNo})
+	if err != nil {
+		return errors.New("Unable to create composite key BusinessID~InvoiceDate~InvoiceNo:" + err.Error())
+	}
+	invoiceBytes, _ := json.Marshal(invoice)
+	return stub.PutState(invoiceKey, invoiceBytes)
+}
+
+func getTaxInvoices(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> BusinessID
+		args[1] -> from date (dd/mm/yyyy)
+		args[2] -> to date (dd/mm/yyyy), both inclusive
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getTaxInvoices(charges) (required:3) given:" + xLenStr)
+	}
+
+	fromDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid from date in getTaxInvoices:" + err.Error())
+	}
+	toDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Invalid to date in getTaxInvoices:" + err.Error())
+	}
+	if toDate.Before(fromDate) {
+		return shim.Error("To date " + args[2] + " is before the from date " + args[1])
+	}
+
+	invoiceIterator, err := stub.GetStateByPartialCompositeKey("BusinessID~InvoiceDate~InvoiceNo", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to fetch the invoices:" + err.Error())
+	}
+	defer invoiceIterator.Close()
+
+	from := fromDate.Format("2006-01-02")
+	to := toDate.Format("2006-01-02")
+	report := invoiceReport{args[0], fromDate, toDate, 0, 0, 0, 0, 0, []taxInvoice{}}
+	for invoiceIterator.HasNext() {
+		invoiceData, err := invoiceIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the invoices:" + err.Error())
+		}
+		_, attributes, err := stub.SplitCompositeKey(invoiceData.Key)
+		if err != nil {
+			return shim.Error("Unable to split the invoice key:" + err.Error())
+		}
+		if attributes[1] < from || attributes[1] > to {
+			continue
+		}
+
+		invoice := taxInvoice{}
+		err = json.Unmarshal(invoiceData.Value, &invoice)
+		if err != nil {
+			return shim.Error("Unable to parse the invoice:" + err.Error())
+		}
+		report.Fee += invoice.Fee
+		report.CGST += invoice.CGST
+		report.SGST += invoice.SGST
+		report.IGST += invoice.IGST
+		report.Total += invoice.Total
+		report.Invoices = append(report.Invoices, invoice)
+	}
+
+	reportBytes, _ := json.Marshal(report)
+	return shim.Success(reportBytes)
+}
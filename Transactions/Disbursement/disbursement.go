@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -20,6 +22,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newDisbInfo" {
 		//Creates new disbursement info
 		return newDisbInfo(stub, args)
@@ -53,20 +60,40 @@ func newDisbInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	//Getting the sanction amount and the status
 	chaincodeArgs := toChaincodeArgs("loanStatusSancAmt", args[3])
 	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
+	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
 	statusNamt := strings.Split(string(response.Payload), ",")
-	if (statusNamt[0] != "sanctioned") || (statusNamt[0] != "part disbursed") {
+	if (statusNamt[0] != "sanctioned") && (statusNamt[0] != "part disbursed") {
 		return shim.Error("loan status for loanID " + args[3] + " is not Sanctioned / part disbursed")
 	}
 
 	sancAmt, _ := strconv.ParseInt(statusNamt[1], 10, 64)
 
+	//A disputed or cancelled instrument cannot be financed
+	chaincodeArgs = toChaincodeArgs("getLoanInfo", args[3])
+	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+	loanIns := struct {
+		InstNum          string
+		SellerBusinessID string
+	}{}
+	err := json.Unmarshal(response.Payload, &loanIns)
+	if err != nil {
+		return shim.Error("Unable to parse the loan(Disbursement):" + err.Error())
+	}
+	chaincodeArgs = toChaincodeArgs("checkFinanceable", loanIns.InstNum, loanIns.SellerBusinessID)
+	response = stub.InvokeChaincode("instrumentcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return shim.Error(response.Message)
+	}
+
 	//Getting the disbursed wallet
 	chaincodeArgs = toChaincodeArgs("getWalletID", args[3], "disbursed")
 	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
+	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
 	walletid := string(response.Payload)
@@ -100,163 +127,105 @@ func newDisbInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	*/
 
 	//####################################################################################################################
-	//Calling for updating Bank Main_Wallet
+	//Posting every wallet as one journal, the main wallets balance and so do the memo legs, the
+	//business loan and principal outstanding mirroring the bank asset and the loan disbursed
 	//####################################################################################################################
 
-	cAmtString := "0"
-	dAmtString := args[5]
-
-	walletID, openBalString, txnBalString, err := getWalletInfo(stub, args[6], "main", "bankcc", cAmtString, dAmtString)
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		legType    string
+		memo       bool
+		contra     bool
+	}{
+		{"1disb", "bankcc", args[6], "main", "debit", false, false},
+		{"2disb", "businesscc", args[7], "main", "credit", false, false},
+		{"3disb", "businesscc", args[7], "loan", "credit", true, true},
+		{"4disb", "bankcc", args[6], "asset", "credit", true, false},
+		{"5disb", "businesscc", args[7], "principalOut", "credit", true, true},
+		{"6disb", "loancc", args[3], "disbursed", "credit", true, false},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		walletID, err := getWalletID(stub, posting.ccName, posting.id, posting.walletType)
+		if err != nil {
+			return shim.Error(posting.walletType + " WalletID(Disbursement):" + err.Error())
+		}
+		// The TxnID keeps the Txn_Bal_Ledger rows of disbursements on the same loan apart
+		leg := common.JournalLeg{WalletID: walletID, Type: posting.legType, Amt: amt, Memo: posting.memo, Contra: posting.contra}
+		journal.Add(leg, common.ArgsRow(posting.keyPrefix+args[0], args))
+	}
+	_, err = journal.Post(stub)
 	if err != nil {
-		return shim.Error("Bank Main Wallet(Disbursement):" + err.Error())
-	}
-
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList := []string{"1", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr := strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
+		return shim.Error("Wallets(Disbursement):" + err.Error())
 	}
-	fmt.Println(string(response.GetPayload()))
 
-	//#####################################################################################################################
-	//Calling for updating Business Main_Wallet
 	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, openBalString, txnBalString, err = getWalletInfo(stub, args[7], "main", "businesscc", cAmtString, dAmtString)
-	if err != nil {
-		return shim.Error("Business Main Wallet(Disbursement):" + err.Error())
-	}
-
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList = []string{"2", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	fmt.Println(string(response.GetPayload()))
-
-	//####################################################################################################################
-	//Calling for updating Business Loan_Wallet
+	//Calling Loan to change the status
 	//####################################################################################################################
 
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, openBalString, txnBalString, err = getWalletInfo(stub, args[7], "loan", "businesscc", cAmtString, dAmtString)
-	if err != nil {
-		return shim.Error("Business Loan Wallet(Disbursement)" + err.Error())
+	var status string
+	if amtToBeDisburesed == 0 {
+		status = "disbursed"
+	} else if amtToBeDisburesed > 0 {
+		status = "part disbursed"
 	}
 
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList = []string{"3", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
+	//calling to change loan status
+	chaincodeArgs = toChaincodeArgs("updateLoanInfo", args[3], status, "disbursement")
+	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
-	fmt.Println(string(response.GetPayload()))
 
-	//####################################################################################################################
-	//Calling for updating Bank Asset_Wallet
-	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, openBalString, txnBalString, err = getWalletInfo(stub, args[6], "asset", "bankcc", cAmtString, dAmtString)
+	//Disbursed amount moves from sanctioned to outstanding in the program and business limits
+	err = updateLimitUtilization(stub, args[3], "disbursement", amt)
 	if err != nil {
-		return shim.Error("Bank Asset Wallet(Disbursement)" + err.Error())
+		return shim.Error("Limit utilization(Disbursement):" + err.Error())
 	}
+	return shim.Success(nil)
+}
 
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList = []string{"4", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	fmt.Println(string(response.GetPayload()))
-
-	//####################################################################################################################
-	//Calling for updating Business principal O/S Wallet
-	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
+func updateLimitUtilization(stub shim.ChaincodeStubInterface, loanID string, event string, amt int64) error {
 
-	walletID, openBalString, txnBalString, err = getWalletInfo(stub, args[7], "principalOut", "businesscc", cAmtString, dAmtString)
-	if err != nil {
-		return shim.Error("Business principal O/S Wallet(Disbursement)" + err.Error())
-	}
-
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList = []string{"5", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
+	chaincodeArgs := toChaincodeArgs("getLoanInfo", loanID)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	fmt.Println(string(response.GetPayload()))
-
-	//####################################################################################################################
-	//Calling for updating Loan Disbursed Wallet
-	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, openBalString, txnBalString, err = getWalletInfo(stub, args[3], "disbursed", "loancc", cAmtString, dAmtString)
+		return errors.New(response.Message)
+	}
+	loan := struct {
+		ExposureBusinessID string
+		ProgramID          string
+		BuyerBusinessID    string
+		Currency           string
+	}{}
+	err := json.Unmarshal(response.Payload, &loan)
 	if err != nil {
-		return shim.Error("Loan Disbursed Wallet(Disbursement)" + err.Error())
+		return errors.New("Unable to parse the loan " + err.Error())
 	}
+	amtStr := strconv.FormatInt(amt, 10)
 
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-	argsList = []string{"5", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	chaincodeArgs = toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the other chaincode")
-	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
+	chaincodeArgs = toChaincodeArgs("updateUtilization", loan.ProgramID, event, amtStr, loan.Currency, loanID)
+	response = stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return shim.Error(response.Message)
+		return errors.New(response.Message)
 	}
-	fmt.Println(string(response.GetPayload()))
-
-	//####################################################################################################################
-
-	//####################################################################################################################
-	//Calling Loan to change the status
-	//####################################################################################################################
 
-	var status string
-	if amtToBeDisburesed == 0 {
-		status = "disbursed"
-	} else if amtToBeDisburesed > 0 {
-		status = "part disbursed"
+	//The loan counts against the exposure business and the buyer
+	businessIDs := []string{loan.ExposureBusinessID}
+	if loan.BuyerBusinessID != loan.ExposureBusinessID {
+		businessIDs = append(businessIDs, loan.BuyerBusinessID)
 	}
-
-	//calling to change loan status
-	chaincodeArgs = toChaincodeArgs("updateLoanInfo", args[3], status, "disbursement")
-	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
-		return shim.Error(response.Message)
+	for _, businessID := range businessIDs {
+		chaincodeArgs = toChaincodeArgs("updateExposure", businessID, loan.ProgramID, event, amtStr, loan.Currency, loanID)
+		response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return errors.New(response.Message)
+		}
 	}
-	return shim.Success(nil)
+	return nil
 }
 
 func toChaincodeArgs(args ...string) [][]byte {
@@ -267,56 +236,14 @@ func toChaincodeArgs(args ...string) [][]byte {
 	return bargs
 }
 
-func getWalletInfo(stub shim.ChaincodeStubInterface, participantID string, walletType string, ccName string, cAmtStr string, dAmtStr string) (string, string, string, error) {
-
-	// STEP-1
-	// using FromID, get a walletID from bank structure
-	// bankID = bankID
+func getWalletID(stub shim.ChaincodeStubInterface, ccName string, id string, walletType string) (string, error) {
 
-	chaincodeArgs := toChaincodeArgs("getWalletID", participantID, walletType)
+	chaincodeArgs := toChaincodeArgs("getWalletID", id, walletType)
 	response := stub.InvokeChaincode(ccName, chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return "", "", "", errors.New(response.Message)
-	}
-	walletID := string(response.GetPayload())
-
-	// STEP-2
-	// getting Balance from walletID
-	// walletFcn := "getWallet"
-	walletArgs := toChaincodeArgs("getWallet", walletID)
-	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
-	if walletResponse.Status != shim.OK {
-		return "", "", "", errors.New(walletResponse.Message)
-	}
-	openBalString := string(walletResponse.Payload)
-
-	openBal, err := strconv.ParseInt(openBalString, 10, 64)
-	if err != nil {
-		return "", "", "", errors.New("Error in converting the openBalance")
-	}
-	cAmt, err := strconv.ParseInt(cAmtStr, 10, 64)
-	if err != nil {
-		return "", "", "", errors.New("Error in converting the cAmt")
-	}
-	dAmt, err := strconv.ParseInt(dAmtStr, 10, 64)
-	if err != nil {
-		return "", "", "", errors.New("Error in converting the dAmt")
+		return "", errors.New(response.Message)
 	}
-
-	txnBal := openBal - dAmt + cAmt
-	txnBalString := strconv.FormatInt(txnBal, 10)
-
-	// STEP-3
-	// update wallet of ID walletID here, and write it to the wallet_ledger
-	// walletFcn := "updateWallet"
-
-	walletArgs = toChaincodeArgs("updateWallet", walletID, txnBalString)
-	walletResponse = stub.InvokeChaincode("walletcc", walletArgs, "myc")
-	if walletResponse.Status != shim.OK {
-		return "", "", "", errors.New(walletResponse.Message)
-	}
-
-	return walletID, openBalString, txnBalString, nil
+	return string(response.GetPayload()), nil
 }
 
 func getWalletValues(stub shim.ChaincodeStubInterface, walletID string) (int64, error) {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -20,6 +21,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newInterestInfo" {
 		return newInterestInfo(stub, args)
 	}
@@ -68,7 +74,7 @@ func newInterestInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respons
 	// Must be Existing Loan with Status as Collected
 	chaincodeArgs := toChaincodeArgs("loanStatusSancAmt", args[3])
 	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
+	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
 	status := strings.Split(string(response.Payload), ",")[0]
@@ -119,154 +125,43 @@ func newInterestInfo(stub shim.ChaincodeStubInterface, args []string) pb.Respons
 	//####################################################################################################################
 
 	//#####################################################################################################################
-	//Calling for updating Business Main_Wallet
+	//Posting the main wallets with the Bank Refund_Wallet and the Bank Revenue/Charges Wallet as one journal,
+	//the Bank Contra Wallet mirroring the two of them
 	//####################################################################################################################
 
-	cAmtString := args[5]
-	dAmtString := "0"
-
-	walletID, err := getWalletID(stub, "businesscc", args[7], "main")
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		legType    string
+		memo       bool
+		contra     bool
+	}{
+		{"1IR", "bankcc", args[6], "main", "debit", false, false},
+		{"2IR", "businesscc", args[7], "main", "credit", false, false},
+		{"3IR", "bankcc", args[6], "liability", "debit", true, false},
+		{"4IR", "bankcc", args[6], "charges", "debit", true, false},
+		{"5IR", "bankcc", args[6], "contra", "debit", true, true},
+		{"6IR", "bankcc", args[6], "contra", "debit", true, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		walletID, err := getWalletID(stub, posting.ccName, posting.id, posting.walletType)
+		if err != nil {
+			return shim.Error("Interest Refund " + posting.walletType + " WalletID " + err.Error())
+		}
+		// The TxnID keeps the Txn_Bal_Ledger rows of refunds on the same loan apart
+		leg := common.JournalLeg{WalletID: walletID, Type: posting.legType, Amt: amt, Memo: posting.memo, Contra: posting.contra}
+		journal.Add(leg, common.ArgsRow(posting.keyPrefix+args[0], args))
+	}
+	_, err = journal.Post(stub)
 	if err != nil {
-		return shim.Error("Interest Refund Business Main WalletID " + err.Error())
-	}
-
-	openBalance, err := getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Interest Refund Business Main WalletValue " + err.Error())
-	}
-	openBalString := strconv.FormatInt(openBalance, 10)
-	bal := openBalance + amt
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	txnBalString := strconv.FormatInt(bal, 10)
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-
-	argsList := []string{"1IR", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr := strings.Join(argsList, ",")
-	txnResponse := putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Interest Refund Wallets " + err.Error())
 	}
 
 	//####################################################################################################################
-	//Calling for updating Bank Main_Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	dAmtString = args[5]
-
-	walletID, err = getWalletID(stub, "bankcc", args[6], "main")
-	if err != nil {
-		return shim.Error("Interest Refund Bank Main WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Interest Refund Bank Main WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"2IR", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Bank Refund_Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	dAmtString = args[5]
-
-	walletID, err = getWalletID(stub, "bankcc", args[6], "liability")
-	if err != nil {
-		return shim.Error("Interest Refund Bank Refund_WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Interest Refund Bank Refund_WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
 
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"3IR", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Bank Revenue/Charges Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	dAmtString = args[5]
-
-	walletID, err = getWalletID(stub, "bankcc", args[6], "charges")
-	if err != nil {
-		return shim.Error("Interest Refund Bank Revenue/Charges WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Interest Refund Bank Revenue/Charges WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"4IR", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-
-	return shim.Success(nil)
-}
-
-func putInTxnBal(stub shim.ChaincodeStubInterface, argsListStr string) pb.Response {
-
-	chaincodeArgs := toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the txnbalcc chaincode from Interest Refund")
-	response := stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	fmt.Println(string(response.Payload))
 	return shim.Success(nil)
 }
 
@@ -293,21 +188,13 @@ func getWalletValue(stub shim.ChaincodeStubInterface, walletID string) (int64, e
 		return 0, errors.New(walletResponse.Message)
 	}
 	balString := string(walletResponse.Payload)
-	balance, _ := strconv.ParseInt(balString, 10, 64)
+	balance, err := strconv.ParseInt(balString, 10, 64)
+	if err != nil {
+		return 0, errors.New("Error in converting the wallet balance " + balString)
+	}
 	return balance, nil
 }
 
-func walletUpdation(stub shim.ChaincodeStubInterface, walletID string, amt int64) pb.Response {
-
-	txnBalString := strconv.FormatInt(amt, 10)
-	walletArgs := toChaincodeArgs("updateWallet", walletID, txnBalString)
-	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
-	if walletResponse.Status != shim.OK {
-		return shim.Error(walletResponse.Message)
-	}
-	return shim.Success(nil)
-
-}
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"newPICinfo":          {Chaincodes: []string{"txncc"}},
+	"getPenalInterestDue": {Roles: []string{"*"}},
+	"addPenalCollection":  {Chaincodes: []string{"txncc"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
@@ -20,8 +22,19 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newPICinfo" {
 		return newPICinfo(stub, args)
+	} else if function == "getPenalInterestDue" {
+		//Returns the penal interest due on a loan as of a date
+		return getPenalInterestDue(stub, args)
+	} else if function == "addPenalCollection" {
+		//Records penal interest collected through a repayment
+		return addPenalCollection(stub, args)
 	}
 	return shim.Error("no function named " + function + " found in Interest Refund")
 }
@@ -31,9 +44,9 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) == 1 {
 		args = strings.Split(args[0], ",")
 	}
-	if len(args) != 10 {
+	if (len(args) != 10) && (len(args) != 11) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in newPICinfo(Interest Refund) (required:10) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in newPICinfo(Interest Refund) (required:10 or 11) given:" + xLenStr)
 	}
 
 	/*
@@ -46,6 +59,7 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	 *ToID    string    //args[7]  Bank
 	 *By      string    //args[8]
 	 *PprID   string    //args[9]
+	 *TDS     int64     //args[10] (optional, worked out at the business TDS rate otherwise)
 	 */
 
 	amt, _ := strconv.ParseInt(args[5], 10, 64)
@@ -61,6 +75,7 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		        b. Debiting (Decreasing) Bank Wallet
 		        c. Debiting (Decreasing) Bank Refund Wallet
 		        d. Debiting (Decreasing) Bank Revenue Wallet
+		        e. Crediting (Increasing) Bank TDS Receivable Wallet with the tax withheld on the penal interest
 	*/
 
 	//Validations
@@ -68,7 +83,7 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Must be Existing Loan with Status as Collected
 	chaincodeArgs := toChaincodeArgs("loanStatusSancAmt", args[3])
 	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status == shim.OK {
+	if response.Status != shim.OK {
 		return shim.Error(response.Message)
 	}
 	status := strings.Split(string(response.Payload), ",")[0]
@@ -81,141 +96,79 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error("Transaction Amount in Penal Interest Collectionis less than or equal to zero")
 	}
 
-	//####################################################################################################################
-
-	//#####################################################################################################################
-	//Calling for updating Business Main_Wallet
-	//####################################################################################################################
-
-	cAmtString := "0"
-	dAmtString := args[5]
-
-	walletID, err := getWalletID(stub, "businesscc", args[6], "main")
+	//TXN Amt must not exceed the penal interest due on the transaction date
+	txnDate, err := time.Parse("02/01/2006", args[2])
 	if err != nil {
-		return shim.Error("Penal Interest CollectionBusiness Main WalletID " + err.Error())
+		return shim.Error("Penal Interest Collection TxnDate " + err.Error())
 	}
-
-	openBalance, err := getWalletValue(stub, walletID)
+	penalDue, err := penalInterestDue(stub, args[3], txnDate)
 	if err != nil {
-		return shim.Error("Penal Interest CollectionBusiness Main WalletValue " + err.Error())
+		return shim.Error("Penal Interest Collection penal interest due " + err.Error())
 	}
-	openBalString := strconv.FormatInt(openBalance, 10)
-	bal := openBalance - amt
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	txnBalString := strconv.FormatInt(bal, 10)
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-
-	argsList := []string{"1PIC", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr := strings.Join(argsList, ",")
-	txnResponse := putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Bank Main_Wallet
-	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, err = getWalletID(stub, "bankcc", args[7], "main")
-	if err != nil {
-		return shim.Error("Penal Interest CollectionBank Main WalletID " + err.Error())
+	if amt > penalDue {
+		return shim.Error("Transaction Amount in Penal Interest Collection " + args[5] + " exceeds the penal interest due " + strconv.FormatInt(penalDue, 10))
 	}
 
-	openBalance, err = getWalletValue(stub, walletID)
+	//Penal interest paid net of the tax withheld by the business is settled along with the tax
+	tds, tdsRate, err := common.TDSDeducted(stub, args, amt, penalDue-amt)
 	if err != nil {
-		return shim.Error("Penal Interest Collection Bank Main WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance + amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"2PIC", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Penal Interest Collection " + err.Error())
 	}
+	settled := amt + tds
 
 	//####################################################################################################################
-	//Calling for updating Business Charges O/s Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	dAmtString = args[5]
 
-	walletID, err = getWalletID(stub, "businesscc", args[7], "interestOut")
-	if err != nil {
-		return shim.Error("Penal Interest Collection Business Charges O/s WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Penal Interest Collection Business Charges O/s WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"3PIC", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Loan Charges Wallet
+	//#####################################################################################################################
+	//Posting the main wallets, the Business Charges O/s Wallet, the Loan Charges Wallet and the Bank TDS Receivable Wallet as one journal,
+	//the Business Charges O/s Wallet mirroring the Loan Charges Wallet and the Bank Contra Wallet the Bank TDS Receivable Wallet
 	//####################################################################################################################
 
-	cAmtString = "0"
-	dAmtString = args[5]
-
-	walletID, err = getWalletID(stub, "loancc", args[7], "charges")
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		cAmt       int64
+		dAmt       int64
+		memo       bool
+		contra     bool
+	}{
+		{"1PIC", "businesscc", args[6], "main", 0, amt, false, false},
+		{"2PIC", "bankcc", args[7], "main", amt, 0, false, false},
+		{"3PIC", "businesscc", args[6], "interestOut", 0, settled, true, true},
+		{"4PIC", "loancc", args[3], "charges", 0, settled, true, false},
+		{"5PIC", "bankcc", args[7], "tds", tds, 0, true, false},
+		{"6PIC", "bankcc", args[7], "contra", tds, 0, true, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		if posting.cAmt == 0 && posting.dAmt == 0 {
+			continue
+		}
+		walletID, err := getWalletID(stub, posting.ccName, posting.id, posting.walletType)
+		if err != nil {
+			return shim.Error("Penal Interest Collection " + posting.walletType + " WalletID " + err.Error())
+		}
+		// The TxnID keeps the Txn_Bal_Ledger rows of collections on the same loan apart
+		row := common.ArgsRow(posting.keyPrefix+args[0], args)
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "credit", Amt: posting.cAmt, Memo: posting.memo, Contra: posting.contra}, row)
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "debit", Amt: posting.dAmt, Memo: posting.memo, Contra: posting.contra}, row)
+	}
+	_, err = journal.Post(stub)
 	if err != nil {
-		return shim.Error("Penal Interest Collection Loan Charges Wallet WalletID " + err.Error())
+		return shim.Error("Penal Interest Collection Wallets " + err.Error())
 	}
 
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Penal Interest Collection Loan Charges WalletValue " + err.Error())
+	if tds > 0 {
+		err = common.RecordTDS(stub, args, settled, tds, tdsRate)
+		if err != nil {
+			return shim.Error("Penal Interest Collection TDS " + err.Error())
+		}
 	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
 
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"4PIC", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+	err = recordPenalCollection(stub, args[3], settled, txnDate)
+	if err != nil {
+		return shim.Error("Penal Interest Collection recording the collection " + err.Error())
 	}
 
 	//####################################################################################################################
@@ -223,18 +176,6 @@ func newPICinfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	return shim.Success(nil)
 }
 
-func putInTxnBal(stub shim.ChaincodeStubInterface, argsListStr string) pb.Response {
-
-	chaincodeArgs := toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the txnbalcc chaincode from Interest Refund")
-	response := stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	fmt.Println(string(response.Payload))
-	return shim.Success(nil)
-}
-
 func getWalletID(stub shim.ChaincodeStubInterface, ccName string, id string, walletType string) (string, error) {
 
 	// STEP-1
@@ -258,21 +199,13 @@ func getWalletValue(stub shim.ChaincodeStubInterface, walletID string) (int64, e
 		return 0, errors.New(walletResponse.Message)
 	}
 	balString := string(walletResponse.Payload)
-	balance, _ := strconv.ParseInt(balString, 10, 64)
+	balance, err := strconv.ParseInt(balString, 10, 64)
+	if err != nil {
+		return 0, errors.New("Error in converting the wallet balance " + balString)
+	}
 	return balance, nil
 }
 
-func walletUpdation(stub shim.ChaincodeStubInterface, walletID string, amt int64) pb.Response {
-
-	txnBalString := strconv.FormatInt(amt, 10)
-	walletArgs := toChaincodeArgs("updateWallet", walletID, txnBalString)
-	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
-	if walletResponse.Status != shim.OK {
-		return shim.Error(walletResponse.Message)
-	}
-	return shim.Success(nil)
-
-}
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Fields of loancc's loanInfo required for the penal interest calculation
+type loanInfo struct {
+	ProgramID             string
+	ExposureBusinessID    string
+	DueDate               time.Time
+	LoanStatus            string
+	LoanDisbursedWalletID string
+}
+
+type penalCollectionInfo struct {
+	Collected          int64
+	LastCollectionDate time.Time
+}
+
+func getPenalInterestDue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> LoanID
+		args[1] -> as of date (dd/mm/yyyy)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getPenalInterestDue(Penal Interest Collection) (required:2) given:" + xLenStr)
+	}
+
+	asOfDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid as of date in getPenalInterestDue:" + err.Error())
+	}
+
+	penalDue, err := penalInterestDue(stub, args[0], asOfDate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.FormatInt(penalDue, 10)))
+}
+
+func addPenalCollection(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> LoanID
+		args[1] -> amount collected in minor units
+		args[2] -> collection date (dd/mm/yyyy)
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in addPenalCollection(Penal Interest Collection) (required:3) given:" + xLenStr)
+	}
+
+	amt, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid amount in addPenalCollection:" + err.Error())
+	}
+	if amt <= 0 {
+		return shim.Error("Penal collection must be greater than zero: " + args[1])
+	}
+	txnDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Invalid collection date in addPenalCollection:" + err.Error())
+	}
+
+	err = recordPenalCollection(stub, args[0], amt, txnDate)
+	if err != nil {
+		return shim.Error("addPenalCollection " + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// penalInterestDue is the penal interest on the loan as of the date, at the
+// penal roi of the PPR of the loan's program and business, or of the program
+func penalInterestDue(stub shim.ChaincodeStubInterface, loanID string, asOfDate time.Time) (int64, error) {
+
+	chaincodeArgs := toChaincodeArgs("getLoanInfo", loanID)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, errors.New(response.Message)
+	}
+	loan := loanInfo{}
+	err := json.Unmarshal(response.Payload, &loan)
+	if err != nil {
+		return 0, errors.New("Unable to parse the loan (penalInterestDue):" + err.Error())
+	}
+
+	daysPastDue := int64(asOfDate.Sub(loan.DueDate).Hours() / 24)
+	if daysPastDue <= 0 {
+		return 0, nil
+	}
+
+	penalRate, err := getPenalROI(stub, "pprcc", loan.ProgramID, loan.ExposureBusinessID)
+	if err != nil {
+		return 0, errors.New("PPR penal roi " + err.Error())
+	}
+	if penalRate == 0 {
+		penalRate, err = getPenalROI(stub, "programcc", loan.ProgramID)
+		if err != nil {
+			return 0, errors.New("Program penal roi " + err.Error())
+		}
+	}
+
+	outstanding, err := getWalletValue(stub, loan.LoanDisbursedWalletID)
+	if err != nil {
+		return 0, errors.New("Loan Disbursed WalletValue " + err.Error())
+	}
+
+	penal := int64(math.Round(float64(outstanding) * penalRate * float64(daysPastDue) / 36500))
+
+	collection, err := getPenalCollection(stub, loanID)
+	if err != nil {
+		return 0, err
+	}
+	penal = penal - collection.Collected
+	if penal < 0 {
+		penal = 0
+	}
+	return penal, nil
+}
+
+func getPenalROI(stub shim.ChaincodeStubInterface, ccName string, ids ...string) (float64, error) {
+
+	chaincodeArgs := toChaincodeArgs(append([]string{"penalROI"}, ids...)...)
+	response := stub.InvokeChaincode(ccName, chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, errors.New(response.Message)
+	}
+	penalRate, err := strconv.ParseFloat(string(response.Payload), 64)
+	if err != nil {
+		return 0, errors.New("Unable to parse the penal roi:" + err.Error())
+	}
+	return penalRate, nil
+}
+
+func getPenalCollection(stub shim.ChaincodeStubInterface, loanID string) (penalCollectionInfo, error) {
+
+	collection := penalCollectionInfo{}
+	collectionBytes, err := stub.GetState(loanID)
+	if err != nil {
+		return collection, err
+	} else if collectionBytes == nil {
+		return collection, nil
+	}
+	err = json.Unmarshal(collectionBytes, &collection)
+	if err != nil {
+		return collection, errors.New("Unable to parse the penal collection (getPenalCollection):" + err.Error())
+	}
+	return collection, nil
+}
+
+func recordPenalCollection(stub shim.ChaincodeStubInterface, loanID string, amt int64, txnDate time.Time) error {
+
+	collection, err := getPenalCollection(stub, loanID)
+	if err != nil {
+		return err
+	}
+	collection.Collected += amt
+	collection.LastCollectionDate = txnDate
+	collectionBytes, _ := json.Marshal(collection)
+	return stub.PutState(loanID, collectionBytes)
+}
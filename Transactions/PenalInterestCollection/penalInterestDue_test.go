@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// fakeCC stands in for another chaincode, answering each function with its handler
+type fakeCC map[string]func(args []string) pb.Response
+
+func (c fakeCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c fakeCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	handler, ok := c[function]
+	if !ok {
+		return shim.Error("no function named " + function + " in the fake chaincode")
+	}
+	return handler(args)
+}
+
+func reply(payload string) func(args []string) pb.Response {
+	return func(args []string) pb.Response { return shim.Success([]byte(payload)) }
+}
+
+func TestPenalInterestDue(t *testing.T) {
+
+	dueDate := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+	loanBytes, _ := json.Marshal(loanInfo{"prog1", "bus1", dueDate, "overdue", "loan1/disbursed"})
+	pprPenalROI := "0"
+
+	stub := shim.NewMockStub("piccc", new(chainCode))
+	peers := map[string]fakeCC{
+		"loancc":    {"getLoanInfo": reply(string(loanBytes))},
+		"pprcc":     {"penalROI": func(args []string) pb.Response { return shim.Success([]byte(pprPenalROI)) }},
+		"programcc": {"penalROI": reply("18.0000")},
+		"walletcc":  {"getWallet": reply("100000")},
+	}
+	for name, cc := range peers {
+		stub.MockPeerChaincode(name+"/myc", shim.NewMockStub(name, cc))
+	}
+
+	due := func(asOfDate string) int64 {
+		t.Helper()
+		stub.MockTransactionStart("due")
+		response := getPenalInterestDue(stub, []string{"loan1", asOfDate})
+		stub.MockTransactionEnd("due")
+		if response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+		var penal int64
+		json.Unmarshal(response.Payload, &penal)
+		return penal
+	}
+
+	if penal := due("30/06/2026"); penal != 0 {
+		t.Errorf("penal on the due date %d, want 0", penal)
+	}
+	// 10 days past due on 1,000.00 at the program's 18%
+	if penal := due("10/07/2026"); penal != 493 {
+		t.Errorf("penal at the program rate %d, want 493", penal)
+	}
+
+	// Penal collected is taken off what is due
+	stub.MockTransactionStart("collect")
+	response := addPenalCollection(stub, []string{"loan1", "200", "10/07/2026"})
+	stub.MockTransactionEnd("collect")
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if penal := due("10/07/2026"); penal != 293 {
+		t.Errorf("penal after a collection of 200 %d, want 293", penal)
+	}
+
+	// The PPR penal roi comes before the program's
+	pprPenalROI = "36.5"
+	if penal := due("10/07/2026"); penal != 800 {
+		t.Errorf("penal at the PPR rate %d, want 800", penal)
+	}
+}
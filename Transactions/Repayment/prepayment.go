@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Fields of loancc's loanInfo required for the allocation
+type repayLoan struct {
+	ProgramID        string
+	SellerBusinessID string
+}
+
+type payoffQuote struct {
+	LoanID    string
+	QuoteDate time.Time
+	Penal     int64
+	Charges   int64
+	Interest  int64
+	Principal int64
+	Rebate    int64
+	Payoff    int64 //repayment that settles the loan on QuoteDate, the rebate is refunded on it
+	NetPayoff int64 //Payoff less the Rebate
+}
+
+func getRepayLoan(stub shim.ChaincodeStubInterface, loanID string) (repayLoan, error) {
+
+	loan := repayLoan{}
+	chaincodeArgs := toChaincodeArgs("getLoanInfo", loanID)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return loan, errors.New(response.Message)
+	}
+	err := json.Unmarshal(response.Payload, &loan)
+	if err != nil {
+		return loan, errors.New("unable to parse the loan " + err.Error())
+	}
+	return loan, nil
+}
+
+// loanDues returns what is owed on the loan in every bucket as of the date
+func loanDues(stub shim.ChaincodeStubInterface, loanID string, dateStr string) (map[string]int64, error) {
+
+	due := map[string]int64{}
+	chaincodeArgs := toChaincodeArgs("getPenalInterestDue", loanID, dateStr)
+	response := stub.InvokeChaincode("piccc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return due, errors.New("penal interest due " + response.Message)
+	}
+	penal, err := strconv.ParseInt(string(response.Payload), 10, 64)
+	if err != nil {
+		return due, errors.New("penal interest due " + err.Error())
+	}
+	due["penal"] = penal
+
+	wallets := map[string]string{
+		"charges":   "charges",
+		"interest":  "accrued",
+		"principal": "disbursed",
+	}
+	for bucket, walletType := range wallets {
+		walletID, err := getWalletID(stub, "loancc", loanID, walletType)
+		if err != nil {
+			return due, errors.New("loan " + walletType + " WalletID " + err.Error())
+		}
+		due[bucket], err = getWalletValue(stub, walletID)
+		if err != nil {
+			return due, errors.New("loan " + walletType + " WalletValue " + err.Error())
+		}
+	}
+	return due, nil
+}
+
+// accruedAfter returns the interest loancc accrued on the loan for the days after the date
+func accruedAfter(stub shim.ChaincodeStubInterface, loanID string, dateStr string) (int64, error) {
+
+	chaincodeArgs := toChaincodeArgs("getAccruedAfter", loanID, dateStr)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return 0, errors.New("interest accrued after " + dateStr + " " + response.Message)
+	}
+	interest, err := strconv.ParseInt(string(response.Payload), 10, 64)
+	if err != nil {
+		return 0, errors.New("interest accrued after " + dateStr + " " + err.Error())
+	}
+	return interest, nil
+}
+
+// interestRebate is the share of the interest accrued after the settlement date
+// that falls on the principal paid, no more than the interest paid on the loan
+func interestRebate(accrued int64, principalDue int64, principal int64, interestPaid int64) int64 {
+
+	if accrued <= 0 || principalDue <= 0 || principal <= 0 {
+		return 0
+	}
+	if principal > principalDue {
+		principal = principalDue
+	}
+	rebate := int64(math.Round(float64(accrued) * float64(principal) / float64(principalDue)))
+	if rebate > interestPaid {
+		rebate = interestPaid
+	}
+	return rebate
+}
+
+func getPayoffQuote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> LoanID
+		args[1] -> settlement date (dd/mm/yyyy)
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getPayoffQuote(repayment) (required:2) given:" + xLenStr)
+	}
+
+	quoteDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid settlement date in getPayoffQuote:" + err.Error())
+	}
+
+	due, err := loanDues(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("getPayoffQuote " + err.Error())
+	}
+
+	accrued, err := accruedAfter(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error("getPayoffQuote " + err.Error())
+	}
+	rebate := interestRebate(accrued, due["principal"], due["principal"], due["interest"])
+	payoff := due["penal"] + due["charges"] + due["interest"] + due["principal"]
+	quote := payoffQuote{args[0], quoteDate, due["penal"], due["charges"], due["interest"], due["principal"], rebate, payoff, payoff - rebate}
+	quoteBytes, _ := json.Marshal(quote)
+	return shim.Success(quoteBytes)
+}
@@ -1,18 +1,36 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
 }
 
+// Split of a repayment between the loan buckets in minor units
+type repaymentAllocation struct {
+	TxnID     string
+	LoanID    string
+	TxnDate   time.Time
+	Order     []string
+	Penal     int64
+	Charges   int64
+	Interest  int64
+	Principal int64
+	Excess    int64 //credited to the bank refund wallet
+	Rebate    int64 //interest accrued after the TxnDate on the principal paid, refunded
+	TDS       int64 //part of Interest withheld by the payer as tax, receivable from the tax authority
+}
+
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Success(nil)
 }
@@ -20,8 +38,16 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newRepayInfo" {
 		return newRepayInfo(stub, args)
+	} else if function == "getPayoffQuote" {
+		//Returns the amount that settles the loan on a date with its interest rebate
+		return getPayoffQuote(stub, args)
 	}
 	return shim.Error("no function named " + function + " found in Repayment")
 }
@@ -31,9 +57,9 @@ func newRepayInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) == 1 {
 		args = strings.Split(args[0], ",")
 	}
-	if len(args) != 10 {
+	if (len(args) != 10) && (len(args) != 11) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in newRepayInfo(repayment) (required:10) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in newRepayInfo(repayment) (required:10 or 11) given:" + xLenStr)
 	}
 
 	/*
@@ -46,445 +72,215 @@ func newRepayInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	 *ToID    string    //args[7]  Bank
 	 *By      string    //args[8]
 	 *PprID   string    //args[9]
+	 *TDS     int64     //args[10] (optional, worked out at the business TDS rate otherwise)
 	 */
 
 	///////////////////////////////////////////////////////////////////////////////////////////////////
 	// 				UPDATING WALLETS																///
 	///////////////////////////////////////////////////////////////////////////////////////////////////
-	// The transaction object has been created and written into the ledger
-	// The JSON file is 'transaction'function
-	// Now to create a TXN_Bal_Update obj for 10 times
-	// Calling TXN_Balance CC based on TXN_Type {ex: Disbursement}
+	// The Txn amt is split between the penal interest due, the loan charges, the accrued interest
+	// and the principal in the allocation order of the program. What is left over is excess.
 	/*
 			    a. Debiting (decreasing) Business Wallet (Buyer)
 		            i. Txn amt
 		        b. Crediting (Increasing) Bank Wallet
 		            i. Txn amt
 		        c. Debiting (decreasing) Bank Asset Wallet
-		            i. Loan Disbursed Wallet Balance + Loan Charges Wallet Balance
+		            i. Charges + Principal allocated
 		        d. Crediting (Increasing) Bank Refund Wallet (if applicable)
-		            i. Txn Amt – Loan Disbursed Wallet balance – Loan Charges Wallet Balance
+		            i. Excess
 		        e. Debiting (decreasing) Business Loan Wallet (Seller)
-		            i. If Txn Amt is >/= (Loan Charges Wallet Balance + Loan Disbursed Wallet Balance)
-		                1. Loan disbursed Wallet Balance + Loan Charges Wallet Balance
-		            ii. If Txn Amt is  < (Loan Charges Wallet Balance + Loan Disbursed Wallet Balance)
-		                1. Txn Amt – Loan Charges Wallet Balance
-		        f. Debiting (decreasing) Business Charges O/s Wallet
-		            i. Loan Charges Wallet Balance
+		            i. Charges + Principal allocated
+		        f. Debiting (decreasing) Business Charges/Interest O/s Wallet
+		            i. Charges + Interest allocated
 		        g. Debiting (decreasing) Business Principal O/s Wallet
-		            i. Loan Disbursed Wallet Balance
+		            i. Principal allocated
 		        h. Debiting (Decreasing) Loan Charges Wallet
-		            i. Loan Charges Wallet Balance
-		        i. Debiting (Decreasing) Loan Disbursed Wallet
-		            i. If Txn Amt is >/= (Loan Charges Wallet Balance + Loan Disbursed Wallet Balance)
-		                1. Loan Disbursed Wallet is reduced to Zero
-		                2. Loan Status is updated to Collected
-		            ii. If Txn Amt is  < (Loan Charges Wallet Balance + Loan Disbursed Wallet Balance)
-		                1. Txn Amt – Loan Charges Wallet Balance
-		                2. Loan Status is updated to Part Collected
-		        j. Debiting (Decreasing) Business Liability Wallet (Buyer)
-		            i. Txn Amt
+		            i. Charges allocated
+		        i. Debiting (Decreasing) Loan Accrued Interest Wallet
+		            i. Interest allocated
+		        j. Debiting (Decreasing) Loan Disbursed Wallet
+		            i. Principal allocated
+		            ii. Loan Status is updated to Collected when every bucket is cleared, else Part Collected
+		        k. Debiting (Decreasing) Business Liability Wallet (Buyer)
+		            i. Txn Amt + TDS
+		        l. Crediting (Increasing) Bank TDS Receivable Wallet
+		            i. TDS withheld on the interest, which settles the interest along with the Txn amt
+		        m. Penal allocated is recorded as collected in piccc
+		        n. Interest accrued after the TxnDate on the principal allocated is rebated, no more than the interest
+		            allocated, paid back from the Bank Wallet to the Business Wallet out of the Bank Revenue/Charges Wallet
+		        o. The Bank and Business Contra Wallets mirror the bank refund, TDS, revenue and business liability legs
 	*/
 
-	amt, _ := strconv.ParseInt(args[5], 10, 64)
-
-	//#####################################################################################################################
-	//Calling for updating Business Main_Wallet
-	//####################################################################################################################
-
-	cAmtString := "0"
-	dAmtString := args[5]
-
-	walletID, err := getWalletID(stub, "businesscc", args[6], "main")
+	amt, err := strconv.ParseInt(args[5], 10, 64)
 	if err != nil {
-		return shim.Error("Repayment Business Main WalletID " + err.Error())
+		return shim.Error("Repayment Txn amt " + err.Error())
 	}
-
-	openBalance, err := getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Business Main WalletValue " + err.Error())
+	if amt <= 0 {
+		return shim.Error("Transaction Amount in Repayment is less than or equal to zero")
 	}
-	openBalString := strconv.FormatInt(openBalance, 10)
-	bal := openBalance - amt
-
-	response := walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	txnBalString := strconv.FormatInt(bal, 10)
-	// STEP-4 generate txn_balance_object and write it to the Txn_Bal_Ledger
-
-	argsList := []string{"1rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr := strings.Join(argsList, ",")
-	txnResponse := putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Bank Main_Wallet
-	//####################################################################################################################
-
-	cAmtString = args[5]
-	dAmtString = "0"
-
-	walletID, err = getWalletID(stub, "bankcc", args[7], "main")
+	txnDate, err := time.Parse("02/01/2006", args[2])
 	if err != nil {
-		return shim.Error("Repayment Bank Main WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Bank Main WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	//amt, _ = strconv.ParseInt(args[5], 10, 64)
-
-	bal = openBalance + amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-	argsList = []string{"2rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Repayment TxnDate " + err.Error())
 	}
 
 	//####################################################################################################################
-	//Calling for updating Bank Asset Wallet
+	//Splitting the Txn amt between the buckets
 	//####################################################################################################################
 
-	cAmtString = "0"
-
-	//Loan Disbursed Wallet balance
-	loanDisbursedWalletID, err := getWalletID(stub, "loancc", args[3], "disbursed")
-	if err != nil {
-		return shim.Error("Repayment loanDisbursedWalletID " + err.Error())
-	}
-	loanDisbursedWalletValue, err := getWalletValue(stub, loanDisbursedWalletID)
-	if err != nil {
-		return shim.Error("Repayment loanDisbursedWalletValue " + err.Error())
-	}
-
-	//Loan Charges Wallet Balance
-	loanChargesWalletID, err := getWalletID(stub, "loancc", args[3], "charges")
-	if err != nil {
-		return shim.Error("Repayment loanChargesWalletID " + err.Error())
-	}
-	loanChargesWalletValue, err := getWalletValue(stub, loanChargesWalletID)
-	if err != nil {
-		return shim.Error("Repayment loanChargesWalletValue " + err.Error())
-	}
-
-	//Bank Asset Wallet
-	walletID, err = getWalletID(stub, "bankcc", args[7], "asset")
-	if err != nil {
-		return shim.Error("Repayment Bank Asset WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
+	loan, err := getRepayLoan(stub, args[3])
 	if err != nil {
-		return shim.Error("Repayment Bank Asset WalletValue " + err.Error())
+		return shim.Error("Repayment " + err.Error())
 	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	bal = openBalance - loanChargesWalletValue - loanDisbursedWalletValue
-	txnBalString = strconv.FormatInt(bal, 10)
 
-	response = walletUpdation(stub, walletID, bal)
+	chaincodeArgs := toChaincodeArgs("getAllocationOrder", loan.ProgramID)
+	response := stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return shim.Error("Repayment Bank Asset Wallet " + response.Message)
-	}
-	dAmt := loanChargesWalletValue + loanDisbursedWalletValue
-	dAmtString = strconv.FormatInt(dAmt, 10)
-	argsList = []string{"3rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Repayment allocation order " + response.Message)
 	}
+	order := strings.Split(string(response.Payload), ";")
 
-	//####################################################################################################################
-	//Calling for updating Bank Refund_Wallet
-	//####################################################################################################################
-
-	dAmtString = "0"
-	walletID, err = getWalletID(stub, "bankcc", args[7], "liability")
+	due, err := loanDues(stub, args[3], args[2])
 	if err != nil {
-		return shim.Error("Repayment Bank Liability WalletID " + err.Error())
+		return shim.Error("Repayment " + err.Error())
 	}
 
-	openBalance, err = getWalletValue(stub, walletID)
+	allocated, excess := allocateRepayment(amt, order, due)
+	//Interest paid net of the tax withheld by the buyer is settled along with the tax
+	tds, tdsRate, err := common.TDSDeducted(stub, args, allocated["interest"], due["interest"]-allocated["interest"])
 	if err != nil {
-		return shim.Error("Repayment Bank Liability WalletValue " + err.Error())
+		return shim.Error("Repayment " + err.Error())
 	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	cAmt := amt - loanChargesWalletValue - loanDisbursedWalletValue
-	if cAmt > 0 {
-		bal = openBalance + cAmt
-	} else {
-		bal = openBalance
-	}
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error("Repayment Bank Liability Wallet " + response.Message)
-	}
-	txnBalString = strconv.FormatInt(bal, 10)
-	cAmtString = strconv.FormatInt(cAmt, 10)
-
-	argsList = []string{"4rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Business Loan_Wallet (seller)
-	//####################################################################################################################
-
-	// geting seller's ID using loan ID
-
-	cAmtString = "0"
-	chaincodeArgs := toChaincodeArgs("getSellerID", args[3])
-	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-
-	sellerID := string(response.Payload)
-	walletID, err = getWalletID(stub, "businesscc", sellerID, "loan")
+	allocated["interest"] += tds
+	//Principal paid before the interest accrued on it is due earns that interest back
+	accrued, err := accruedAfter(stub, args[3], args[2])
 	if err != nil {
-		return shim.Error("Repayment Business Loan_WalletID (seller) " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Business Loan_WalletValue (seller) " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	if (amt > loanChargesWalletValue+loanDisbursedWalletValue) || (amt == loanChargesWalletValue+loanDisbursedWalletValue) {
-		dAmt = loanChargesWalletValue + loanDisbursedWalletValue
-	} else if amt < loanChargesWalletValue+loanDisbursedWalletValue {
-		dAmt = amt - loanChargesWalletValue
-	}
-
-	bal = openBalance - dAmt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error("Repayment Business Loan_Wallet (seller) " + response.Message)
-	}
-	dAmtString = strconv.FormatInt(dAmt, 10)
-
-	argsList = []string{"5rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Repayment " + err.Error())
 	}
+	rebate := interestRebate(accrued, due["principal"], allocated["principal"], allocated["interest"])
+	allocation := repaymentAllocation{args[0], args[3], txnDate, order, allocated["penal"], allocated["charges"], allocated["interest"], allocated["principal"], excess, rebate, tds}
 
 	//####################################################################################################################
-	//Calling for updating Business Charges/Interest O/s Wallet
+	//Posting the main wallets and the bank, business and loan wallets with the allocated amounts as one journal,
+	//the business loan and outstanding wallets mirroring the bank asset and the loan wallets and the contra
+	//wallets the bank refund, TDS and revenue wallets and the business liability wallet
 	//####################################################################################################################
 
-	cAmtString = "0"
-	walletID, err = getWalletID(stub, "businesscc", args[6], "interestOut")
-	if err != nil {
-		return shim.Error("Repayment Business Charges/Interest O/s WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Business Charges/Interest O/s WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-	dAmt = loanChargesWalletValue
-	bal = openBalance - loanChargesWalletValue
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error("Repayment Business Charges/Interest O/s Wallet " + response.Message)
-	}
-	dAmtString = strconv.FormatInt(dAmt, 10)
-
-	argsList = []string{"6rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Business Principal O/s Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	walletID, err = getWalletID(stub, "businesscc", args[6], "principalOut")
-	if err != nil {
-		return shim.Error("Repayment Business Principal O/s WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Business Principal O/s WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	bal = openBalance - loanDisbursedWalletValue
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error("Repayment Business Principal O/s Wallet " + response.Message)
-	}
-	dAmt = loanDisbursedWalletValue
-	dAmtString = strconv.FormatInt(dAmt, 10)
-
-	argsList = []string{"7rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
-	}
-
-	//####################################################################################################################
-	//Calling for updating Loan Charges Wallet
-	//####################################################################################################################
-
-	cAmtString = "0"
-	walletID, err = getWalletID(stub, "loan", args[3], "charges")
-	if err != nil {
-		return shim.Error("Repayment Loan Charges WalletID " + err.Error())
+	walletPostings := []struct {
+		keyPrefix  string
+		ccName     string
+		id         string
+		walletType string
+		cAmt       int64
+		dAmt       int64
+		memo       bool
+		contra     bool
+	}{
+		{"1rep", "businesscc", args[6], "main", 0, amt, false, false},
+		{"2rep", "bankcc", args[7], "main", amt, 0, false, false},
+		{"3rep", "bankcc", args[7], "asset", 0, allocation.Charges + allocation.Principal, true, false},
+		{"4rep", "bankcc", args[7], "liability", allocation.Excess, 0, true, false},
+		{"5rep", "businesscc", loan.SellerBusinessID, "loan", 0, allocation.Charges + allocation.Principal, true, true},
+		{"6rep", "businesscc", args[6], "interestOut", 0, allocation.Charges + allocation.Interest, true, true},
+		{"7rep", "businesscc", args[6], "principalOut", 0, allocation.Principal, true, true},
+		{"8rep", "loancc", args[3], "charges", 0, allocation.Charges, true, false},
+		{"9rep", "loancc", args[3], "accrued", 0, allocation.Interest, true, false},
+		{"10rep", "loancc", args[3], "disbursed", 0, allocation.Principal, true, false},
+		{"11rep", "businesscc", args[6], "liability", 0, amt + allocation.TDS, true, false},
+		{"12rep", "bankcc", args[7], "tds", allocation.TDS, 0, true, false},
+		{"13rep", "bankcc", args[7], "main", 0, allocation.Rebate, false, false},
+		{"14rep", "businesscc", args[6], "main", allocation.Rebate, 0, false, false},
+		{"15rep", "bankcc", args[7], "charges", 0, allocation.Rebate, true, false},
+		{"16rep", "bankcc", args[7], "contra", allocation.Excess + allocation.TDS, allocation.Rebate, true, true},
+		{"17rep", "businesscc", args[6], "contra", 0, amt + allocation.TDS, true, true},
+	}
+	journal := common.Journal{}
+	for _, posting := range walletPostings {
+		if posting.cAmt == 0 && posting.dAmt == 0 {
+			continue
+		}
+		walletID, err := getWalletID(stub, posting.ccName, posting.id, posting.walletType)
+		if err != nil {
+			return shim.Error("Repayment " + posting.walletType + " WalletID " + err.Error())
+		}
+		// The TxnID keeps the Txn_Bal_Ledger rows of repayments on the same loan apart
+		row := common.ArgsRow(posting.keyPrefix+args[0], args)
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "credit", Amt: posting.cAmt, Memo: posting.memo, Contra: posting.contra}, row)
+		journal.Add(common.JournalLeg{WalletID: walletID, Type: "debit", Amt: posting.dAmt, Memo: posting.memo, Contra: posting.contra}, row)
 	}
-
-	openBalance, err = getWalletValue(stub, walletID)
+	_, err = journal.Post(stub)
 	if err != nil {
-		return shim.Error("Repayment Loan Charges WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	bal = openBalance - loanChargesWalletValue
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
-	if response.Status != shim.OK {
-		return shim.Error("Repayment Loan Charges Wallet " + response.Message)
-	}
-	dAmt = loanChargesWalletValue
-	dAmtString = strconv.FormatInt(dAmt, 10)
-
-	argsList = []string{"8rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Repayment Wallets " + err.Error())
 	}
 
 	//####################################################################################################################
-	//Calling for updating Loan Disbursed Wallet
+	//Penal collected, loan status and limits
 	//####################################################################################################################
 
-	cAmtString = "0"
-	walletID, err = getWalletID(stub, "loan", args[3], "disbursed")
-	if err != nil {
-		return shim.Error("Repayment Loan Disbursed WalletID " + err.Error())
-	}
-
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Loan Disbursed WalletValue " + err.Error())
-	}
-	openBalString = strconv.FormatInt(openBalance, 10)
-
-	if (amt > loanChargesWalletValue+loanDisbursedWalletValue) || (amt == loanChargesWalletValue+loanDisbursedWalletValue) {
-		bal = 0
-		dAmt = openBalance
-		chaincodeArgs := toChaincodeArgs("updateLoanInfo", args[3], "repayment", "collected")
-		response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if allocation.Penal > 0 {
+		chaincodeArgs = toChaincodeArgs("addPenalCollection", args[3], strconv.FormatInt(allocation.Penal, 10), args[2])
+		response = stub.InvokeChaincode("piccc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
-			return shim.Error(response.Message)
+			return shim.Error("Repayment penal collection " + response.Message)
 		}
-	} else if amt < loanChargesWalletValue+loanDisbursedWalletValue {
-		dAmt = amt - loanChargesWalletValue
-		bal = openBalance - dAmt
-		chaincodeArgs := toChaincodeArgs("updateLoanInfo", args[3], "repayment", "part collected")
-		response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
-		if response.Status != shim.OK {
-			return shim.Error(response.Message)
+	}
+
+	status := "collected"
+	for _, bucket := range order {
+		if allocated[bucket] < due[bucket] {
+			status = "part collected"
 		}
 	}
-	txnBalString = strconv.FormatInt(bal, 10)
-	response = walletUpdation(stub, walletID, bal)
+	chaincodeArgs = toChaincodeArgs("updateLoanInfo", args[3], "repayment", status)
+	response = stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return shim.Error("Repayment Loan Charges Wallet " + response.Message)
-	}
-	dAmtString = strconv.FormatInt(dAmt, 10)
-
-	argsList = []string{"9rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error(response.Message)
 	}
 
-	//####################################################################################################################
-	//Calling for updating Business Liability Wallet (Buyer)
-	//####################################################################################################################
-
-	cAmtString = "0"
-	dAmtString = args[5]
-
-	walletID, err = getWalletID(stub, "business", args[6], "liability")
-	if err != nil {
-		return shim.Error("Repayment Bank Liability WalletID " + err.Error())
+	//Principal repaid releases the program and business limits
+	if allocation.Principal > 0 {
+		err = updateLimitUtilization(stub, args[3], "repayment", allocation.Principal)
+		if err != nil {
+			return shim.Error("Repayment limit utilization " + err.Error())
+		}
 	}
 
-	openBalance, err = getWalletValue(stub, walletID)
-	if err != nil {
-		return shim.Error("Repayment Bank Liability WalletValue " + err.Error())
+	if allocation.TDS > 0 {
+		err = common.RecordTDS(stub, args, allocation.Interest, allocation.TDS, tdsRate)
+		if err != nil {
+			return shim.Error("Repayment TDS " + err.Error())
+		}
 	}
-	openBalString = strconv.FormatInt(openBalance, 10)
 
-	bal = openBalance - amt
-	txnBalString = strconv.FormatInt(bal, 10)
-
-	response = walletUpdation(stub, walletID, bal)
+	allocationBytes, _ := json.Marshal(allocation)
+	chaincodeArgs = toChaincodeArgs("putAllocation", string(allocationBytes))
+	response = stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
 	if response.Status != shim.OK {
-		return shim.Error(response.Message)
-	}
-
-	argsList = []string{"10rep", args[0], args[2], args[3], args[4], walletID, openBalString, args[1], args[5], cAmtString, dAmtString, txnBalString, args[8]}
-	argsListStr = strings.Join(argsList, ",")
-	txnResponse = putInTxnBal(stub, argsListStr)
-	if txnResponse.Status != shim.OK {
-		return shim.Error(txnResponse.Message)
+		return shim.Error("Repayment allocation " + response.Message)
 	}
 
 	//####################################################################################################################
 
-	return shim.Success(nil)
+	return shim.Success(allocationBytes)
 }
 
-func putInTxnBal(stub shim.ChaincodeStubInterface, argsListStr string) pb.Response {
+// allocateRepayment fills the buckets in order, what cannot be allocated is excess
+func allocateRepayment(amt int64, order []string, due map[string]int64) (map[string]int64, int64) {
 
-	chaincodeArgs := toChaincodeArgs("putTxnInfo", argsListStr)
-	fmt.Println("calling the txnbalcc chaincode from repayment")
-	response := stub.InvokeChaincode("txnbalcc", chaincodeArgs, "myc")
-	if response.Status != shim.OK {
-		return shim.Error(response.Message)
+	allocated := map[string]int64{}
+	remaining := amt
+	for _, bucket := range order {
+		share := due[bucket]
+		if share > remaining {
+			share = remaining
+		}
+		if share < 0 {
+			share = 0
+		}
+		allocated[bucket] = share
+		remaining -= share
 	}
-	fmt.Println(string(response.Payload))
-	return shim.Success(nil)
+	return allocated, remaining
 }
 
 func getWalletID(stub shim.ChaincodeStubInterface, ccName string, id string, walletType string) (string, error) {
@@ -510,21 +306,53 @@ func getWalletValue(stub shim.ChaincodeStubInterface, walletID string) (int64, e
 		return 0, errors.New(walletResponse.Message)
 	}
 	balString := string(walletResponse.Payload)
-	balance, _ := strconv.ParseInt(balString, 10, 64)
+	balance, err := strconv.ParseInt(balString, 10, 64)
+	if err != nil {
+		return 0, errors.New("Error in converting the wallet balance " + balString)
+	}
 	return balance, nil
 }
 
-func walletUpdation(stub shim.ChaincodeStubInterface, walletID string, amt int64) pb.Response {
+func updateLimitUtilization(stub shim.ChaincodeStubInterface, loanID string, event string, amt int64) error {
 
-	txnBalString := strconv.FormatInt(amt, 10)
-	walletArgs := toChaincodeArgs("updateWallet", walletID, txnBalString)
-	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
-	if walletResponse.Status != shim.OK {
-		return shim.Error(walletResponse.Message)
+	chaincodeArgs := toChaincodeArgs("getLoanInfo", loanID)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
+	}
+	loan := struct {
+		ExposureBusinessID string
+		ProgramID          string
+		BuyerBusinessID    string
+		Currency           string
+	}{}
+	err := json.Unmarshal(response.Payload, &loan)
+	if err != nil {
+		return errors.New("Unable to parse the loan " + err.Error())
+	}
+	amtStr := strconv.FormatInt(amt, 10)
+
+	chaincodeArgs = toChaincodeArgs("updateUtilization", loan.ProgramID, event, amtStr, loan.Currency, loanID)
+	response = stub.InvokeChaincode("programcc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New(response.Message)
 	}
-	return shim.Success(nil)
 
+	//The loan counts against the exposure business and the buyer
+	businessIDs := []string{loan.ExposureBusinessID}
+	if loan.BuyerBusinessID != loan.ExposureBusinessID {
+		businessIDs = append(businessIDs, loan.BuyerBusinessID)
+	}
+	for _, businessID := range businessIDs {
+		chaincodeArgs = toChaincodeArgs("updateExposure", businessID, loan.ProgramID, event, amtStr, loan.Currency, loanID)
+		response = stub.InvokeChaincode("businesscc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return errors.New(response.Message)
+		}
+	}
+	return nil
 }
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
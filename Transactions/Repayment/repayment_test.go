@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+// fakeCC stands in for another chaincode, answering each function with its handler
+type fakeCC map[string]func(args []string) pb.Response
+
+func (c fakeCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (c fakeCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	handler, ok := c[function]
+	if !ok {
+		return shim.Error("no function named " + function + " in the fake chaincode")
+	}
+	return handler(args)
+}
+
+// walletIDs names the wallet of an owner as owner/type
+func walletIDs(args []string) pb.Response {
+	return shim.Success([]byte(args[0] + "/" + args[1]))
+}
+
+func ok(args []string) pb.Response {
+	return shim.Success(nil)
+}
+
+// fakeWallets applies postJournal like walletcc, checking the main legs and
+// the memo legs balance, a contra memo leg on the other side
+func fakeWallets(t *testing.T, balances map[string]int64) fakeCC {
+	return fakeCC{
+		"getWallet": func(args []string) pb.Response {
+			return shim.Success([]byte(strconv.FormatInt(balances[args[0]], 10)))
+		},
+		"postJournal": func(args []string) pb.Response {
+			legs := []common.JournalLeg{}
+			if err := json.Unmarshal([]byte(args[0]), &legs); err != nil {
+				return shim.Error(err.Error())
+			}
+			var main, memo int64
+			results := []common.JournalResult{}
+			for _, leg := range legs {
+				result := common.JournalResult{WalletID: leg.WalletID, OpeningBal: balances[leg.WalletID]}
+				signed := leg.Amt
+				if leg.Type == "debit" {
+					signed = -leg.Amt
+					result.DAmt = leg.Amt
+				} else {
+					result.CAmt = leg.Amt
+				}
+				balances[leg.WalletID] += signed
+				if leg.Contra {
+					memo -= signed
+				} else if leg.Memo {
+					memo += signed
+				} else {
+					main += signed
+				}
+				result.TxnBal = balances[leg.WalletID]
+				results = append(results, result)
+			}
+			if main != 0 || memo != 0 {
+				t.Errorf("journal main legs off by %d, memo legs off by %d: %s", main, memo, args[0])
+			}
+			resultsBytes, _ := json.Marshal(results)
+			return shim.Success(resultsBytes)
+		},
+	}
+}
+
+func TestNewRepayInfoWaterfall(t *testing.T) {
+
+	loanBytes, _ := json.Marshal(repayLoan{ProgramID: "prog1", SellerBusinessID: "seller1"})
+	balances := map[string]int64{
+		"loan1/charges":   200,
+		"loan1/accrued":   300,
+		"loan1/disbursed": 10000,
+	}
+	var penalCollected, loanStatus string
+	var limitsReleased []string
+
+	stub := shim.NewMockStub("repaycc", new(chainCode))
+	peers := map[string]fakeCC{
+		"loancc": {
+			"getLoanInfo": func(args []string) pb.Response { return shim.Success(loanBytes) },
+			"getWalletID": walletIDs,
+			"getAccruedAfter": func(args []string) pb.Response {
+				if args[1] != "05/07/2026" {
+					return shim.Error("accrued after " + args[1])
+				}
+				return shim.Success([]byte("50"))
+			},
+			"updateLoanInfo": func(args []string) pb.Response {
+				loanStatus = strings.Join(args, ",")
+				return shim.Success(nil)
+			},
+		},
+		"programcc": {
+			"getAllocationOrder": func(args []string) pb.Response { return shim.Success([]byte("penal;charges;interest;principal")) },
+			"updateUtilization": func(args []string) pb.Response {
+				limitsReleased = append(limitsReleased, "program "+strings.Join(args, ","))
+				return shim.Success(nil)
+			},
+		},
+		"businesscc": {
+			"getWalletID": walletIDs,
+			"updateExposure": func(args []string) pb.Response {
+				limitsReleased = append(limitsReleased, "business "+strings.Join(args, ","))
+				return shim.Success(nil)
+			},
+		},
+		"bankcc": {"getWalletID": walletIDs},
+		"piccc": {
+			"getPenalInterestDue": func(args []string) pb.Response { return shim.Success([]byte("100")) },
+			"addPenalCollection": func(args []string) pb.Response {
+				penalCollected = strings.Join(args, ",")
+				return shim.Success(nil)
+			},
+		},
+		"walletcc": fakeWallets(t, balances),
+		"txnbalcc": {"putTxnInfo": ok, "putAllocation": ok},
+	}
+	for name, cc := range peers {
+		stub.MockPeerChaincode(name+"/myc", shim.NewMockStub(name, cc))
+	}
+
+	// 10,600 clears penal 100, charges 200, interest 300 and principal 10,000 of a loan,
+	// the 50 of interest accrued after the repayment date coming back as a rebate
+	args := []string{"txn1", "repayment", "05/07/2026", "loan1", "inst1", "10600", "buyer1", "bank1", "maker", "ppr1", "0"}
+	stub.MockTransactionStart("txn1")
+	response := newRepayInfo(stub, args)
+	stub.MockTransactionEnd("txn1")
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	allocation := repaymentAllocation{}
+	if err := json.Unmarshal(response.Payload, &allocation); err != nil {
+		t.Fatal(err)
+	}
+	if allocation.Penal != 100 || allocation.Charges != 200 || allocation.Interest != 300 || allocation.Principal != 10000 || allocation.Excess != 0 || allocation.Rebate != 50 {
+		t.Fatalf("allocation %+v", allocation)
+	}
+	for _, walletID := range []string{"loan1/charges", "loan1/accrued", "loan1/disbursed"} {
+		if balances[walletID] != 0 {
+			t.Errorf("%s left at %d", walletID, balances[walletID])
+		}
+	}
+	if balances["bank1/main"] != 10550 || balances["buyer1/main"] != -10550 {
+		t.Errorf("main wallets bank %d, buyer %d", balances["bank1/main"], balances["buyer1/main"])
+	}
+	if balances["bank1/charges"] != -50 || balances["bank1/liability"] != 0 {
+		t.Errorf("rebate taken from bank charges %d, bank liability %d", balances["bank1/charges"], balances["bank1/liability"])
+	}
+	if penalCollected != "loan1,100,05/07/2026" {
+		t.Errorf("penal collection %q", penalCollected)
+	}
+	if loanStatus != "loan1,repayment,collected" {
+		t.Errorf("loan status update %q", loanStatus)
+	}
+	if len(limitsReleased) == 0 || !strings.HasPrefix(limitsReleased[0], "program prog1,repayment,10000") {
+		t.Errorf("limits released %v", limitsReleased)
+	}
+}
+
+func TestAllocateRepayment(t *testing.T) {
+
+	due := map[string]int64{"penal": 100, "charges": 200, "interest": 300, "principal": 10000}
+	order := []string{"penal", "charges", "interest", "principal"}
+
+	allocated, excess := allocateRepayment(450, order, due)
+	if allocated["penal"] != 100 || allocated["charges"] != 200 || allocated["interest"] != 150 || allocated["principal"] != 0 || excess != 0 {
+		t.Fatalf("part payment allocated %v, excess %d", allocated, excess)
+	}
+
+	allocated, excess = allocateRepayment(11000, order, due)
+	if allocated["principal"] != 10000 || excess != 400 {
+		t.Fatalf("over payment allocated %v, excess %d", allocated, excess)
+	}
+}
+
+func TestInterestRebate(t *testing.T) {
+
+	cases := []struct {
+		name                                       string
+		accrued, principalDue, principal, interest int64
+		want                                       int64
+	}{
+		{"nothing accrued after the date", 0, 10000, 10000, 300, 0},
+		{"full principal paid", 120, 10000, 10000, 300, 120},
+		{"part principal paid", 120, 10000, 2500, 300, 30},
+		{"capped at the interest paid", 120, 10000, 10000, 80, 80},
+		{"no principal paid", 120, 10000, 0, 300, 0},
+		{"principal over the due", 120, 10000, 12000, 300, 120},
+	}
+	for _, c := range cases {
+		if got := interestRebate(c.accrued, c.principalDue, c.principal, c.interest); got != c.want {
+			t.Errorf("%s: rebate %d, want %d", c.name, got, c.want)
+		}
+	}
+}
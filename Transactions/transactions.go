@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -15,15 +16,17 @@ type chainCode struct {
 }
 
 type transactionInfo struct {
-	TxnType string    //args[1]
-	TxnDate time.Time //args[2]
-	LoanID  string    //args[3]
-	InsID   string    //args[4]
-	Amt     int64     //args[5]
-	FromID  string    //args[6]
-	ToID    string    //args[7]
-	By      string    //args[8]
-	PprID   string    //args[9]
+	TxnType  string    //args[1]
+	TxnDate  time.Time //args[2]
+	LoanID   string    //args[3]
+	InsID    string    //args[4]
+	Amt      int64     //args[5]
+	FromID   string    //args[6]
+	ToID     string    //args[7]
+	By       string    //args[8]
+	PprID    string    //args[9]
+	Currency string    //Amt is in minor units of this currency
+	TDS      int64     //args[10]//tax withheld by the payer on the interest
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -33,6 +36,11 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "newTxnInfo" {
 		//Creates new Transaction Information
 		return newTxnInfo(stub, args)
@@ -44,9 +52,9 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 }
 
 func newTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 10 {
+	if (len(args) != 10) && (len(args) != 11) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in newTxnInfo(transactions) (required:10) given: " + xLenStr)
+		return shim.Error("Invalid number of arguments in newTxnInfo(transactions) (required:10 or 11) given: " + xLenStr)
 	}
 
 	tTypeValues := map[string]bool{
@@ -55,6 +63,11 @@ func newTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		"margin refund":             true,
 		"interest refund":           true,
 		"penal interest collection": true,
+		"write off":                 true,
+		"write off recovery":        true,
+		"charges":                   true,
+		"cersai charges":            true,
+		"factor regn charges":       true,
 	}
 
 	//Converting into lower case for comparison
@@ -74,6 +87,24 @@ func newTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error(err.Error())
 	}
 
+	//TDS -> args[10], only interest payments carry it
+	var tds int64
+	if len(args) == 11 {
+		if (tTypeLower != "repayment") && (tTypeLower != "penal interest collection") {
+			return shim.Error("TDS cannot be given for a " + tTypeLower + " transaction")
+		}
+		tds, err = strconv.ParseInt(args[10], 10, 64)
+		if err != nil {
+			return shim.Error("TDS " + err.Error())
+		}
+	}
+
+	//Amt is in the currency of the loan
+	currency, err := loanCurrency(stub, args[3])
+	if err != nil {
+		return shim.Error("Loan currency " + err.Error())
+	}
+
 	//TODO: put it at last for redability
 
 	switch tTypeLower {
@@ -81,96 +112,133 @@ func newTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	case "disbursement":
 		argsStr := strings.Join(args, ",")
 		chaincodeArgs := toChaincodeArgs("newDisbInfo", argsStr)
-		fmt.Println("calling the disbursement chaincode")
 		response := stub.InvokeChaincode("disbursementcc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
 			return shim.Error(response.Message)
 		}
-		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9]}
-		fmt.Println(transaction)
-
-		txnBytes, err := json.Marshal(transaction)
-		err = stub.PutState(args[0], txnBytes)
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
 		if err != nil {
-			return shim.Error("Cannot write into ledger the transactino details")
+			return shim.Error(err.Error())
 		}
-		fmt.Println("Successfully inserted disbursement transaction into the ledger")
 
 		//chaincodeArgs = toChaincodeArgs("updateLoanBal",)
 
 	case "repayment":
 		argsStr := strings.Join(args, ",")
 		chaincodeArgs := toChaincodeArgs("newRepayInfo", argsStr)
-		fmt.Println("calling the repayment chaincode")
 		response := stub.InvokeChaincode("repaycc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
 			return shim.Error(response.Message)
 		}
-		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9]}
-		fmt.Println(transaction)
-		txnBytes, err := json.Marshal(transaction)
-		err = stub.PutState(args[0], txnBytes)
+		//The allocation carries the TDS worked out at the business rate when none was given
+		allocation := struct {
+			TDS int64
+		}{}
+		if json.Unmarshal(response.Payload, &allocation) == nil {
+			tds = allocation.TDS
+		}
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
 		if err != nil {
-			return shim.Error("Cannot write into ledger the transaction details")
+			return shim.Error(err.Error())
 		}
-		fmt.Println("Successfully inserted repayment transaction into the ledger")
 	case "margin refund":
 		argsStr := strings.Join(args, ",")
 		chaincodeArgs := toChaincodeArgs("newMarginInfo", argsStr)
-		fmt.Println("calling the marginrefundcc chaincode")
 		response := stub.InvokeChaincode("marginrefundcc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
 			return shim.Error(response.Message)
 		}
-		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9]}
-		fmt.Println(transaction)
-		txnBytes, err := json.Marshal(transaction)
-		err = stub.PutState(args[0], txnBytes)
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
 		if err != nil {
-			return shim.Error("Cannot write into ledger the transaction details")
+			return shim.Error(err.Error())
 		}
-		fmt.Println("Successfully inserted margin refund transaction into the ledger")
 	case "interest refund":
 		argsStr := strings.Join(args, ",")
 		chaincodeArgs := toChaincodeArgs("newInterestInfo", argsStr)
-		fmt.Println("calling the interestrefundcc chaincode")
 		response := stub.InvokeChaincode("interestrefundcc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
 			return shim.Error(response.Message)
 		}
-		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9]}
-		fmt.Println(transaction)
-		txnBytes, err := json.Marshal(transaction)
-		err = stub.PutState(args[0], txnBytes)
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
 		if err != nil {
-			return shim.Error("Cannot write into ledger the transaction details")
+			return shim.Error(err.Error())
 		}
-		fmt.Println("Successfully inserted interest refund transaction into the ledger")
 	case "penal interest collection":
 		argsStr := strings.Join(args, ",")
 		chaincodeArgs := toChaincodeArgs("newPICinfo", argsStr)
-		fmt.Println("calling the piccc chaincode")
 		response := stub.InvokeChaincode("piccc", chaincodeArgs, "myc")
 		if response.Status != shim.OK {
 			return shim.Error(response.Message)
 		}
-		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9]}
-		fmt.Println(transaction)
-		txnBytes, err := json.Marshal(transaction)
-		err = stub.PutState(args[0], txnBytes)
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
 		if err != nil {
-			return shim.Error("Cannot write into ledger the transaction details")
+			return shim.Error(err.Error())
+		}
+	case "write off":
+		argsStr := strings.Join(args, ",")
+		chaincodeArgs := toChaincodeArgs("writeOffLoan", argsStr)
+		response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error(response.Message)
 		}
-		fmt.Println("Successfully inserted penal interest collection transaction into the ledger")
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	case "write off recovery":
+		argsStr := strings.Join(args, ",")
+		chaincodeArgs := toChaincodeArgs("recoverWriteOff", argsStr)
+		response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error(response.Message)
+		}
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	case "charges", "cersai charges", "factor regn charges":
+		argsStr := strings.Join(args, ",")
+		chaincodeArgs := toChaincodeArgs("newChargesInfo", argsStr)
+		response := stub.InvokeChaincode("chargescc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error(response.Message)
+		}
+		transaction := transactionInfo{tTypeLower, tDate, args[3], args[4], amt, args[6], args[7], args[8], args[9], currency, tds}
+		err = putTransaction(stub, args[0], transaction)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		//The tax invoice goes back to the caller
+		return shim.Success(response.Payload)
 
 	default:
-		fmt.Println("incorrect txnType")
 		return shim.Error("incorrect txnType from txncc")
 	}
 
 	return shim.Success(nil)
 }
 
+// putTransaction writes the transaction under its TxnID
+func putTransaction(stub shim.ChaincodeStubInterface, txnID string, transaction transactionInfo) error {
+
+	txnBytes, err := json.Marshal(transaction)
+	if err != nil {
+		return errors.New("Unable to marshal the transaction details " + err.Error())
+	}
+	err = stub.PutState(txnID, txnBytes)
+	if err != nil {
+		return errors.New("Cannot write into ledger the transaction details " + err.Error())
+	}
+	return nil
+}
+
 func getTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 1 {
 		xLenStr := strconv.Itoa(len(args))
@@ -195,6 +263,23 @@ func getTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 }
 
+func loanCurrency(stub shim.ChaincodeStubInterface, loanID string) (string, error) {
+
+	chaincodeArgs := toChaincodeArgs("getLoanInfo", loanID)
+	response := stub.InvokeChaincode("loancc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return "", errors.New(response.Message)
+	}
+	loan := struct {
+		Currency string
+	}{}
+	err := json.Unmarshal(response.Payload, &loan)
+	if err != nil {
+		return "", errors.New("Unable to parse the loan " + err.Error())
+	}
+	return loan.Currency, nil
+}
+
 func toChaincodeArgs(args ...string) [][]byte {
 	bargs := make([][]byte, len(args))
 	for i, arg := range args {
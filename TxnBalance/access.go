@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"putTxnInfo":         {Chaincodes: []string{"loancc", "approvalcc", "txncc", "disbursementcc", "repaycc", "marginrefundcc", "interestrefundcc", "piccc", "chargescc"}},
+	"getTxnBalInfo":      {Roles: []string{"*"}},
+	"getWalletStatement": {Roles: []string{"*"}},
+	"reindexTxnBal":      {Roles: []string{"platform admin"}},
+	"putAllocation":      {Chaincodes: []string{"txncc"}},
+	"getAllocation":      {Roles: []string{"*"}},
+	"reconcileWallets":   {Roles: []string{"*"}},
+	"reconcileOwner":     {Roles: []string{"*"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
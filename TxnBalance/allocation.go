@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Split of a repayment between the loan buckets in minor units, as allocated by repaycc
+type repaymentAllocation struct {
+	TxnID     string
+	LoanID    string
+	TxnDate   time.Time
+	Order     []string
+	Penal     int64
+	Charges   int64
+	Interest  int64
+	Principal int64
+	Excess    int64 //credited to the bank refund wallet
+	Rebate    int64 //interest refunded for principal paid before the due date
+	TDS       int64 //part of Interest withheld by the payer as tax, receivable from the tax authority
+}
+
+func putAllocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> repayment allocation as JSON
+	*/
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in putAllocation (required:1) given:" + xLenStr)
+	}
+
+	allocation := repaymentAllocation{}
+	err := json.Unmarshal([]byte(args[0]), &allocation)
+	if err != nil {
+		return shim.Error("Unable to parse the allocation (TxnBalance):" + err.Error())
+	}
+	if allocation.TxnID == "" {
+		return shim.Error("Allocation has no TxnID")
+	}
+
+	allocationKey, err := stub.CreateCompositeKey("TxnID~Allocation", []string{allocation.TxnID})
+	if err != nil {
+		return shim.Error("Unable to create composite key TxnID~Allocation:" + err.Error())
+	}
+	ifExists, err := stub.GetState(allocationKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if ifExists != nil {
+		return shim.Error("Allocation of TxnID " + allocation.TxnID + " exists")
+	}
+
+	allocationBytes, _ := json.Marshal(allocation)
+	err = stub.PutState(allocationKey, allocationBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func getAllocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getAllocation (required:1) given:" + xLenStr)
+	}
+
+	allocationKey, err := stub.CreateCompositeKey("TxnID~Allocation", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to create composite key TxnID~Allocation:" + err.Error())
+	}
+	allocationBytes, err := stub.GetState(allocationKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if allocationBytes == nil {
+		return shim.Error("No allocation is avalilable on this TxnID " + args[0])
+	}
+	return shim.Success(allocationBytes)
+}
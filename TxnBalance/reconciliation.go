@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type reconBreak struct {
+	TxnBalID string //empty when the wallet moved after the last recorded row
+	TxnID    string
+	Seq      int64
+	TxnDate  time.Time
+	Reason   string
+	Expected int64
+	Recorded int64
+}
+
+type walletRecon struct {
+	WalletID    string
+	Rows        int
+	ReplayedBal int64
+	WalletBal   int64
+	Reconciled  bool
+	Breaks      []reconBreak //in posting order, the first one is where the divergence appeared
+}
+
+// Wallet types held by each owner, as named in their getWalletID
+var ownerWallets = map[string][]string{
+	"bank":     {"main", "asset", "charges", "liability", "tds", "provision", "writeoff", "cgst", "sgst", "igst", "contra"},
+	"business": {"main", "loan", "liability", "principalOut", "interestOut", "contra"},
+	"loan":     {"disbursed", "charges", "accrued"},
+}
+
+func reconcileWallets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args -> WalletIDs to be reconciled
+	*/
+	if len(args) == 0 {
+		return shim.Error("Invalid number of arguments in reconcileWallets (required:atleast 1) given: 0")
+	}
+
+	recons := []walletRecon{}
+	for _, walletID := range args {
+		recon, err := reconcileWallet(stub, walletID)
+		if err != nil {
+			return shim.Error("reconcileWallets " + walletID + ": " + err.Error())
+		}
+		recons = append(recons, recon)
+	}
+
+	reconsBytes, _ := json.Marshal(recons)
+	return shim.Success(reconsBytes)
+}
+
+func reconcileOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> owner type (bank, business or loan)
+		args[1] -> BankID, BusinessID or LoanID
+	*/
+	if len(args) != 2 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in reconcileOwner (required:2) given:" + xLenStr)
+	}
+
+	walletTypes, ok := ownerWallets[args[0]]
+	if !ok {
+		return shim.Error("Invalid owner type for reconciliation: " + args[0])
+	}
+
+	recons := []walletRecon{}
+	for _, walletType := range walletTypes {
+		var chaincodeArgs [][]byte
+		if args[0] == "loan" {
+			// loancc reads its arguments as one comma separated string
+			chaincodeArgs = toChaincodeArgs("getWalletID", args[1]+","+walletType)
+		} else {
+			chaincodeArgs = toChaincodeArgs("getWalletID", args[1], walletType)
+		}
+		response := stub.InvokeChaincode(args[0]+"cc", chaincodeArgs, "myc")
+		if response.Status != shim.OK {
+			return shim.Error("reconcileOwner " + walletType + " WalletID: " + response.Message)
+		}
+		// Wallets added after the owner was created are empty until they are added
+		if len(response.Payload) == 0 {
+			continue
+		}
+
+		recon, err := reconcileWallet(stub, string(response.Payload))
+		if err != nil {
+			return shim.Error("reconcileOwner " + walletType + " wallet: " + err.Error())
+		}
+		recons = append(recons, recon)
+	}
+
+	reconsBytes, _ := json.Marshal(recons)
+	return shim.Success(reconsBytes)
+}
+
+func reconcileWallet(stub shim.ChaincodeStubInterface, walletID string) (walletRecon, error) {
+
+	recon := walletRecon{WalletID: walletID, Breaks: []reconBreak{}}
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey("WalletID~TxnDate~Seq~TxnBalID", []string{walletID})
+	if err != nil {
+		return recon, err
+	}
+	defer indexIterator.Close()
+
+	type row struct {
+		txnBalID string
+		value    txnBalanceInfo
+	}
+	rows := []row{}
+	for indexIterator.HasNext() {
+		indexData, err := indexIterator.Next()
+		if err != nil {
+			return recon, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexData.Key)
+		if err != nil {
+			return recon, err
+		}
+		txnBalance, err := readTxnBal(stub, keyParts[3])
+		if err != nil {
+			return recon, err
+		}
+		rows = append(rows, row{keyParts[3], txnBalance})
+	}
+
+	// Replay in posting order, the index itself is ordered by TxnDate
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i].value, rows[j].value
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		if !a.TxnDate.Equal(b.TxnDate) {
+			return a.TxnDate.Before(b.TxnDate)
+		}
+		return rows[i].txnBalID < rows[j].txnBalID
+	})
+
+	var replayed int64
+	for i, r := range rows {
+		if i == 0 {
+			replayed = r.value.OpeningBal
+		} else if r.value.OpeningBal != replayed {
+			recon.Breaks = append(recon.Breaks, reconBreak{r.txnBalID, r.value.TxnID, r.value.Seq, r.value.TxnDate, "opening balance differs from the previous closing balance", replayed, r.value.OpeningBal})
+		}
+
+		replayed = replayed + r.value.CAmt - r.value.DAmt
+		if r.value.TxnBal != replayed {
+			recon.Breaks = append(recon.Breaks, reconBreak{r.txnBalID, r.value.TxnID, r.value.Seq, r.value.TxnDate, "closing balance differs from the replayed balance", replayed, r.value.TxnBal})
+		}
+	}
+	recon.Rows = len(rows)
+	recon.ReplayedBal = replayed
+
+	walletArgs := toChaincodeArgs("getWallet", walletID)
+	walletResponse := stub.InvokeChaincode("walletcc", walletArgs, "myc")
+	if walletResponse.Status != shim.OK {
+		return recon, errors.New(walletResponse.Message)
+	}
+	recon.WalletBal, err = strconv.ParseInt(string(walletResponse.Payload), 10, 64)
+	if err != nil {
+		return recon, errors.New("Error in converting the wallet balance " + string(walletResponse.Payload))
+	}
+
+	if (len(rows) != 0) && (recon.WalletBal != replayed) {
+		last := rows[len(rows)-1]
+		recon.Breaks = append(recon.Breaks, reconBreak{"", last.value.TxnID, last.value.Seq, last.value.TxnDate, "wallet balance moved after the last recorded row", replayed, recon.WalletBal})
+	}
+	recon.Reconciled = len(recon.Breaks) == 0
+	return recon, nil
+}
+
+func toChaincodeArgs(args ...string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i, arg := range args {
+		bargs[i] = []byte(arg)
+	}
+	return bargs
+}
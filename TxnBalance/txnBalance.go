@@ -27,6 +27,8 @@ type txnBalanceInfo struct {
 	DAmt       int64
 	TxnBal     int64
 	By         string
+	Currency   string //amounts are in minor units of this currency
+	Seq        int64  //position of the movement in the wallet statement
 }
 
 func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -35,10 +37,27 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	if function == "putTxnInfo" { //Inserting a New Business information
 		return putTxnInfo(stub, args)
 	} else if function == "getTxnBalInfo" { // To view a Transaction Balance
 		return getTxnBalInfo(stub, args)
+	} else if function == "getWalletStatement" { // Movements of a wallet between two dates
+		return getWalletStatement(stub, args)
+	} else if function == "reindexTxnBal" { // Indexes the rows written before wallet statements
+		return reindexTxnBal(stub, args)
+	} else if function == "reconcileWallets" { // Replays the rows of wallets against walletcc balances
+		return reconcileWallets(stub, args)
+	} else if function == "reconcileOwner" { // Reconciles every wallet of a bank, business or loan
+		return reconcileOwner(stub, args)
+	} else if function == "putAllocation" { // Records how a repayment was split between the loan buckets
+		return putAllocation(stub, args)
+	} else if function == "getAllocation" { // Returns the split of a repayment
+		return getAllocation(stub, args)
 	}
 	return shim.Error("No function named " + function + " in TxnBalancessssss")
 }
@@ -48,9 +67,9 @@ func putTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) == 1 {
 		args = strings.Split(args[0], ",")
 	}
-	if len(args) != 13 {
+	if (len(args) < 13) || (len(args) > 15) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in putTxnInfo (required:13) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in putTxnInfo (required:13 to 15) given:" + xLenStr)
 	}
 	fmt.Println("Printing args")
 	fmt.Println(args)
@@ -76,7 +95,12 @@ func putTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		"tds":                 true,
 		"penal charges":       true,
 		"cersai carges":       true,
+		"cersai charges":      true,
 		"factor regn charges": true,
+		"accrued interest":    true,
+		"provision":           true,
+		"write off":           true,
+		"write off recovery":  true,
 	}
 
 	txnTypeLower := strings.ToLower(args[7])
@@ -110,7 +134,32 @@ func putTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error("TxnBalanceId " + args[0] + " exits. Cannot create new ID")
 	}
 
-	txnBalance := txnBalanceInfo{args[1], txnDate, args[3], args[4], args[5], openBal, txnTypeLower, amt, cAmt, dAmt, txnBal, args[12]}
+	//Currency -> args[13], INR when not given
+	currency := "INR"
+	if len(args) >= 14 {
+		currency = args[13]
+	}
+
+	//Movements of the wallet earlier in the same transaction -> args[14]
+	var walletLeg int64
+	if len(args) == 15 {
+		walletLeg, err = strconv.ParseInt(args[14], 10, 64)
+		if err != nil {
+			return shim.Error("err in wallet leg (TxnBalance)" + err.Error())
+		}
+	}
+
+	seq, err := walletSeq(stub, args[5])
+	if err != nil {
+		return shim.Error("err in wallet sequence (TxnBalance)" + err.Error())
+	}
+	seq += walletLeg + 1
+	err = putWalletSeq(stub, args[5], seq)
+	if err != nil {
+		return shim.Error("err in wallet sequence (TxnBalance)" + err.Error())
+	}
+
+	txnBalance := txnBalanceInfo{args[1], txnDate, args[3], args[4], args[5], openBal, txnTypeLower, amt, cAmt, dAmt, txnBal, args[12], currency, seq}
 	txnBalanceBytes, err := json.Marshal(txnBalance)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -119,6 +168,10 @@ func putTxnInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = putStatementIndex(stub, args[0], txnBalance)
+	if err != nil {
+		return shim.Error("err in statement index (TxnBalance)" + err.Error())
+	}
 	//fmt.Println("Transaction :", txnBalance)
 	fmt.Printf("Succefully wrote txnID %s into the ledger\n", args[0])
 
@@ -147,10 +200,24 @@ func getTxnBalInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response
 	if err != nil {
 		return shim.Error("Unable to parse TxnBalance into the structure " + err.Error())
 	}
+	migrateLegacyTxnBal(&txnBalance)
 	//fmt.Println("Unmarshled TxnBalance function")
 	jsonString := fmt.Sprintf("%+v", txnBalance)
 	fmt.Printf("Transaction info %s : %s\n", args[0], jsonString)
-	return shim.Success(nil)
+	txnBalanceBytes, _ = json.Marshal(txnBalance)
+	return shim.Success(txnBalanceBytes)
+}
+
+// Rows written before minor units carry rupee amounts and no currency
+func migrateLegacyTxnBal(txnBalance *txnBalanceInfo) {
+	if txnBalance.Currency == "" {
+		txnBalance.OpeningBal = txnBalance.OpeningBal * 100
+		txnBalance.Amt = txnBalance.Amt * 100
+		txnBalance.CAmt = txnBalance.CAmt * 100
+		txnBalance.DAmt = txnBalance.DAmt * 100
+		txnBalance.TxnBal = txnBalance.TxnBal * 100
+		txnBalance.Currency = "INR"
+	}
 }
 
 func main() {
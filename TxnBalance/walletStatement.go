@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type statementEntry struct {
+	TxnBalID   string
+	Seq        int64
+	TxnID      string
+	TxnDate    time.Time
+	LoanID     string
+	InsID      string
+	TxnType    string
+	OpeningBal int64
+	CAmt       int64
+	DAmt       int64
+	TxnBal     int64
+	By         string
+}
+
+type walletStatement struct {
+	WalletID   string
+	FromDate   time.Time
+	ToDate     time.Time
+	Currency   string
+	OpeningBal int64 //balance before the first movement in the period
+	ClosingBal int64 //balance after the last movement in the period
+	Entries    []statementEntry
+}
+
+// walletSeq returns the last statement position of the wallet. Movements on
+// the same date are listed in the order they were posted. GetState does not
+// see the writes of the running transaction, so a transaction moving a wallet
+// more than once numbers its movements from this position itself.
+func walletSeq(stub shim.ChaincodeStubInterface, walletID string) (int64, error) {
+
+	seqKey, err := stub.CreateCompositeKey("WalletSeq", []string{walletID})
+	if err != nil {
+		return 0, err
+	}
+	seqBytes, err := stub.GetState(seqKey)
+	if err != nil || seqBytes == nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(seqBytes), 10, 64)
+}
+
+func putWalletSeq(stub shim.ChaincodeStubInterface, walletID string, seq int64) error {
+
+	seqKey, err := stub.CreateCompositeKey("WalletSeq", []string{walletID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(seqKey, []byte(strconv.FormatInt(seq, 10)))
+}
+
+func putStatementIndex(stub shim.ChaincodeStubInterface, txnBalID string, txnBalance txnBalanceInfo) error {
+
+	indexKey, err := stub.CreateCompositeKey("WalletID~TxnDate~Seq~TxnBalID", []string{txnBalance.WalletID, txnBalance.TxnDate.Format("2006-01-02"), fmt.Sprintf("%012d", txnBalance.Seq), txnBalID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func getWalletStatement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		args[0] -> WalletID
+		args[1] -> from date (dd/mm/yyyy)
+		args[2] -> to date (dd/mm/yyyy)
+	*/
+	if len(args) != 3 {
+		xLenStr := strconv.Itoa(len(args))
+		return shim.Error("Invalid number of arguments in getWalletStatement (required:3) given:" + xLenStr)
+	}
+
+	fromDate, err := time.Parse("02/01/2006", args[1])
+	if err != nil {
+		return shim.Error("Invalid from date (getWalletStatement):" + err.Error())
+	}
+	toDate, err := time.Parse("02/01/2006", args[2])
+	if err != nil {
+		return shim.Error("Invalid to date (getWalletStatement):" + err.Error())
+	}
+	if toDate.Before(fromDate) {
+		return shim.Error("To date " + args[2] + " is before from date " + args[1])
+	}
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey("WalletID~TxnDate~Seq~TxnBalID", []string{args[0]})
+	if err != nil {
+		return shim.Error("Unable to fetch the wallet movements (getWalletStatement):" + err.Error())
+	}
+	defer indexIterator.Close()
+
+	statement := walletStatement{args[0], fromDate, toDate, "", 0, 0, []statementEntry{}}
+	for indexIterator.HasNext() {
+		indexData, err := indexIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the wallet movements (getWalletStatement):" + err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexData.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// Dates sort as text in the index, so the scan stops after the period
+		txnDate, _ := time.Parse("2006-01-02", keyParts[1])
+		if txnDate.After(toDate) {
+			break
+		}
+
+		txnBalance, err := readTxnBal(stub, keyParts[3])
+		if err != nil {
+			return shim.Error("getWalletStatement " + err.Error())
+		}
+		statement.Currency = txnBalance.Currency
+		if txnDate.Before(fromDate) {
+			statement.OpeningBal = txnBalance.TxnBal
+			continue
+		}
+
+		if len(statement.Entries) == 0 {
+			statement.OpeningBal = txnBalance.OpeningBal
+		}
+		statement.Entries = append(statement.Entries, statementEntry{keyParts[3], txnBalance.Seq, txnBalance.TxnID, txnBalance.TxnDate, txnBalance.LoanID, txnBalance.InsID, txnBalance.TxnType, txnBalance.OpeningBal, txnBalance.CAmt, txnBalance.DAmt, txnBalance.TxnBal, txnBalance.By})
+	}
+
+	statement.ClosingBal = statement.OpeningBal
+	if len(statement.Entries) != 0 {
+		statement.ClosingBal = statement.Entries[len(statement.Entries)-1].TxnBal
+	}
+
+	statementBytes, _ := json.Marshal(statement)
+	return shim.Success(statementBytes)
+}
+
+func readTxnBal(stub shim.ChaincodeStubInterface, txnBalID string) (txnBalanceInfo, error) {
+
+	txnBalance := txnBalanceInfo{}
+	txnBalanceBytes, err := stub.GetState(txnBalID)
+	if err != nil {
+		return txnBalance, err
+	} else if txnBalanceBytes == nil {
+		return txnBalance, fmt.Errorf("No information is avalilable on this TxnBalID %s", txnBalID)
+	}
+	err = json.Unmarshal(txnBalanceBytes, &txnBalance)
+	if err != nil {
+		return txnBalance, err
+	}
+	migrateLegacyTxnBal(&txnBalance)
+	return txnBalance, nil
+}
+
+func reindexTxnBal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+		Rows written before wallet statements carry no Seq. They are given one in
+		TxnDate order per wallet and added to the statement index.
+	*/
+	rowsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("Unable to fetch the transaction balances (reindexTxnBal):" + err.Error())
+	}
+	defer rowsIterator.Close()
+
+	type legacyRow struct {
+		txnBalID string
+		value    txnBalanceInfo
+	}
+	legacyRows := []legacyRow{}
+	for rowsIterator.HasNext() {
+		rowData, err := rowsIterator.Next()
+		if err != nil {
+			return shim.Error("Unable to iterate the transaction balances (reindexTxnBal):" + err.Error())
+		}
+		txnBalance := txnBalanceInfo{}
+		err = json.Unmarshal(rowData.Value, &txnBalance)
+		if err != nil || txnBalance.WalletID == "" || txnBalance.Seq != 0 {
+			continue
+		}
+		legacyRows = append(legacyRows, legacyRow{rowData.Key, txnBalance})
+	}
+
+	// Rows of a date are ordered by TxnID and then by the TxnBalID, which
+	// carries the leg of the transaction
+	sort.SliceStable(legacyRows, func(i, j int) bool {
+		a, b := legacyRows[i].value, legacyRows[j].value
+		if !a.TxnDate.Equal(b.TxnDate) {
+			return a.TxnDate.Before(b.TxnDate)
+		}
+		if a.TxnID != b.TxnID {
+			return a.TxnID < b.TxnID
+		}
+		return legacyRows[i].txnBalID < legacyRows[j].txnBalID
+	})
+
+	// The positions are counted here, the writes of this run not being visible to GetState
+	seqs := map[string]int64{}
+	for _, row := range legacyRows {
+		seq, found := seqs[row.value.WalletID]
+		if !found {
+			seq, err = walletSeq(stub, row.value.WalletID)
+			if err != nil {
+				return shim.Error("reindexTxnBal wallet sequence " + err.Error())
+			}
+		}
+		seq++
+		seqs[row.value.WalletID] = seq
+		row.value.Seq = seq
+		rowBytes, _ := json.Marshal(row.value)
+		err = stub.PutState(row.txnBalID, rowBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = putStatementIndex(stub, row.txnBalID, row.value)
+		if err != nil {
+			return shim.Error("reindexTxnBal statement index " + err.Error())
+		}
+	}
+	for walletID, seq := range seqs {
+		err = putWalletSeq(stub, walletID, seq)
+		if err != nil {
+			return shim.Error("reindexTxnBal wallet sequence " + err.Error())
+		}
+	}
+	return shim.Success([]byte(strconv.Itoa(len(legacyRows))))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestReindexTxnBal(t *testing.T) {
+
+	stub := shim.NewMockStub("txnbalcc", new(chainCode))
+	day := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	legacy := map[string]txnBalanceInfo{
+		"2repB": {TxnID: "B", TxnDate: day, WalletID: "w1", Currency: "INR"},
+		"1repB": {TxnID: "B", TxnDate: day, WalletID: "w1", Currency: "INR"},
+		"1repA": {TxnID: "A", TxnDate: day, WalletID: "w1", Currency: "INR"},
+		"1disX": {TxnID: "X", TxnDate: day.AddDate(0, 0, -1), WalletID: "w1", Currency: "INR"},
+	}
+	stub.MockTransactionStart("setup")
+	for txnBalID, row := range legacy {
+		rowBytes, _ := json.Marshal(row)
+		stub.PutState(txnBalID, rowBytes)
+	}
+	stub.MockTransactionEnd("setup")
+
+	stub.MockTransactionStart("reindex")
+	response := reindexTxnBal(stub, nil)
+	stub.MockTransactionEnd("reindex")
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// Earlier dates first, then by TxnID and by the leg in the TxnBalID
+	for txnBalID, want := range map[string]int64{"1disX": 1, "1repA": 2, "1repB": 3, "2repB": 4} {
+		row, err := readTxnBal(stub, txnBalID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row.Seq != want {
+			t.Errorf("%s Seq %d, want %d", txnBalID, row.Seq, want)
+		}
+	}
+	if seq, _ := walletSeq(stub, "w1"); seq != 4 {
+		t.Errorf("wallet sequence %d after the reindex, want 4", seq)
+	}
+}
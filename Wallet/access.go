@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+var permissions = map[string]common.Permission{
+	"newWallet":     {Chaincodes: []string{"bankcc", "businesscc", "loancc", "approvalcc"}},
+	"getWallet":     {Roles: []string{"*"}},
+	"postJournal":   {Chaincodes: []string{"loancc", "approvalcc", "txncc", "disbursementcc", "repaycc", "marginrefundcc", "interestrefundcc", "piccc", "chargescc"}},
+	"migrateWallet": {Roles: []string{"platform admin"}},
+}
+
+func checkAccess(stub shim.ChaincodeStubInterface, function string) error {
+	return common.CheckAccess(stub, function, permissions)
+}
@@ -2,17 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
 )
 
 type chainCode struct {
 }
 
+// All the amounts are integer minor units (paise for INR) with an explicit currency.
+// Wallets written before this carried a float Balance in rupees and no currency,
+// they are converted when read and rewritten by migrateWallet.
 type walletsInfo struct {
+	Balance  int64 //minor units
+	Currency string
+}
+
+type legacyWalletsInfo struct {
 	Balance float64
 }
 
@@ -22,12 +34,21 @@ func (c *chainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
+
+	err := checkAccess(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	if function == "newWallet" {
 		return newWallet(stub, args)
 	} else if function == "getWallet" {
 		return getWallet(stub, args)
-	} else if function == "updateWallet" {
-		return updateWallet(stub, args)
+	} else if function == "postJournal" {
+		//Applies a balanced set of debit and credit legs across wallets
+		return postJournal(stub, args)
+	} else if function == "migrateWallet" {
+		//Rewrites float rupee wallets as minor unit wallets
+		return migrateWallet(stub, args)
 	}
 	return shim.Error("No function named " + function + " in Wallet")
 
@@ -36,14 +57,25 @@ func (c *chainCode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 //Creating new Wallet
 
 func newWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
+
+	/*
+	*args[0] -> WalletID
+	*args[1] -> Opening balance in minor units
+	*args[2] -> Currency (optional, INR by default)
+	 */
+	if (len(args) != 2) && (len(args) != 3) {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in newWallet (required:2) given:" + xLenStr)
+		return shim.Error("Invalid number of arguments in newWallet (required:2 or 3) given:" + xLenStr)
 	}
 
-	bal64, err := strconv.ParseFloat(args[1], 64)
+	bal64, err := strconv.ParseInt(args[1], 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("Invalid wallet balance (minor units) " + args[1] + ": " + err.Error())
+	}
+
+	currency := "INR"
+	if len(args) == 3 {
+		currency = strings.ToUpper(args[2])
 	}
 
 	ifExists, err := stub.GetState(args[0])
@@ -51,69 +83,255 @@ func newWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		return shim.Error("WalletId " + args[0] + " exits. Cannot create new ID")
 	}
 
-	bal := walletsInfo{bal64}
+	bal := walletsInfo{bal64, currency}
 	balBytes, _ := json.Marshal(bal)
 	err = stub.PutState(args[0], balBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
+func getWalletState(stub shim.ChaincodeStubInterface, walletID string) (walletsInfo, bool, error) {
+
+	bal := walletsInfo{}
+	balBytes, err := stub.GetState(walletID)
+	if err != nil {
+		return bal, false, err
+	} else if balBytes == nil {
+		return bal, false, errors.New("No data exists on this WalletId: " + walletID)
+	}
+
+	err = json.Unmarshal(balBytes, &bal)
+	if err == nil && bal.Currency != "" {
+		return bal, false, nil
+	}
+
+	// Legacy wallet, float balance in rupees
+	legacy := legacyWalletsInfo{}
+	err = json.Unmarshal(balBytes, &legacy)
+	if err != nil {
+		return bal, false, err
+	}
+	bal = walletsInfo{int64(math.Round(legacy.Balance * 100)), "INR"}
+	return bal, true, nil
+}
+
 func getWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) != 1 {
 		xLenStr := strconv.Itoa(len(args))
 		return shim.Error("Invalid number of arguments in getWallet (required:1) given: " + xLenStr)
 	}
-	balBytes, err := stub.GetState(args[0])
-	if err != nil {
-		return shim.Error(err.Error())
-	} else if balBytes == nil {
-		return shim.Error("No data exists on this WalletId: " + args[0])
-	}
-	bal := walletsInfo{}
-	err = json.Unmarshal(balBytes, &bal)
+	bal, _, err := getWalletState(stub, args[0])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	balString := fmt.Sprintf("%+v", bal)
 	fmt.Printf("Wallet %s : %s\n", args[0], balString)
 
-	balStr := strconv.FormatFloat(bal.Balance, 'f', 4, 64)
+	balStr := strconv.FormatInt(bal.Balance, 10)
 	return shim.Success([]byte(balStr))
 }
 
-func updateWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func postJournal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	/*
-	*args[0] -> WalletID
-	*args[1] -> Wallet Ballance
+	*args[0] -> JSON array of legs [{"WalletID":"..","Type":"debit","Amt":100},..]
+	*Debits and credits must balance in every currency, legs in different
+	*currencies are balanced only through conversion legs. Memo legs balance
+	*among themselves in the same way, a contra memo leg counting on the
+	*other side.
 	 */
-	if len(args) != 2 {
+	if len(args) != 1 {
 		xLenStr := strconv.Itoa(len(args))
-		return shim.Error("Invalid number of arguments in Wallet Updation (required:2) given: " + xLenStr)
+		return shim.Error("Invalid number of arguments in postJournal (required:1) given: " + xLenStr)
+	}
+
+	legs := []common.JournalLeg{}
+	err := json.Unmarshal([]byte(args[0]), &legs)
+	if err != nil {
+		return shim.Error("Unable to parse the journal legs (postJournal): " + err.Error())
 	}
-	balBytes, err := stub.GetState(args[0])
+	if len(legs) == 0 {
+		return shim.Error("Journal requires at least one leg")
+	}
+
+	// The same wallet can appear in more than one leg, so balances are
+	// carried in memory and written once all the legs are applied
+	balances := map[string]*walletsInfo{}
+	debits := map[string]int64{}
+	credits := map[string]int64{}
+	memoDebits := map[string]int64{}
+	memoCredits := map[string]int64{}
+	for i, leg := range legs {
+		if leg.Amt <= 0 {
+			return shim.Error("Journal leg amount must be greater than zero for WalletId: " + leg.WalletID)
+		}
+		if leg.Contra && !leg.Memo {
+			return shim.Error("Only a memo leg can be a contra leg, WalletId: " + leg.WalletID)
+		}
+
+		if leg.Type == "conversion" {
+			err = checkConversion(stub, leg)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			credits[leg.Currency] += leg.Amt
+			debits[leg.ToCurrency] += leg.ConvertedAmt
+			continue
+		}
+
+		if leg.WalletID == "" {
+			return shim.Error("Journal leg has no WalletId, the bank or business has no wallet of that type")
+		}
+		bal, ok := balances[leg.WalletID]
+		if !ok {
+			walletBal, _, err := getWalletState(stub, leg.WalletID)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			bal = &walletBal
+			balances[leg.WalletID] = bal
+		}
+		if leg.Currency == "" {
+			legs[i].Currency = bal.Currency
+		} else if leg.Currency != bal.Currency {
+			return shim.Error("Journal leg currency " + leg.Currency + " does not match WalletId: " + leg.WalletID + " currency " + bal.Currency)
+		}
+
+		if (leg.Type != "debit") && (leg.Type != "credit") {
+			return shim.Error("Invalid journal leg type " + leg.Type + " for WalletId: " + leg.WalletID)
+		}
+		legDebits, legCredits := debits, credits
+		if leg.Memo {
+			legDebits, legCredits = memoDebits, memoCredits
+			if leg.Contra {
+				legDebits, legCredits = memoCredits, memoDebits
+			}
+		}
+		if leg.Type == "debit" {
+			legDebits[bal.Currency] += leg.Amt
+		} else {
+			legCredits[bal.Currency] += leg.Amt
+		}
+	}
+
+	err = checkBalanced("Journal is", debits, credits)
 	if err != nil {
 		return shim.Error(err.Error())
-	} else if balBytes == nil {
-		return shim.Error("No data exists on this WalletId: " + args[0])
 	}
-	bal := walletsInfo{}
-	err = json.Unmarshal(balBytes, &bal)
+	err = checkBalanced("Journal memo legs are", memoDebits, memoCredits)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	bal.Balance, err = strconv.ParseFloat(args[1], 64)
-	if err != nil {
-		return shim.Error("Error in Wallet updation parse int" + err.Error())
+	results := []common.JournalResult{}
+	for _, leg := range legs {
+		if leg.Type == "conversion" {
+			continue
+		}
+		bal := balances[leg.WalletID]
+		result := common.JournalResult{WalletID: leg.WalletID, OpeningBal: bal.Balance}
+		if leg.Type == "debit" {
+			bal.Balance -= leg.Amt
+			result.DAmt = leg.Amt
+		} else {
+			bal.Balance += leg.Amt
+			result.CAmt = leg.Amt
+		}
+		result.TxnBal = bal.Balance
+		results = append(results, result)
 	}
 
-	balBytes, _ = json.Marshal(bal)
-	err = stub.PutState(args[0], balBytes)
+	for walletID, bal := range balances {
+		balBytes, _ := json.Marshal(bal)
+		err = stub.PutState(walletID, balBytes)
+		if err != nil {
+			return shim.Error("Error in Wallet updation " + err.Error())
+		}
+	}
+
+	resultsBytes, _ := json.Marshal(results)
+	return shim.Success(resultsBytes)
+}
+
+// checkBalanced reports the first currency whose debits and credits differ
+func checkBalanced(legs string, debits map[string]int64, credits map[string]int64) error {
+
+	for currency, debit := range debits {
+		if debit != credits[currency] {
+			return fmt.Errorf("%s not balanced in %s debits: %d credits: %d", legs, currency, debit, credits[currency])
+		}
+	}
+	for currency, credit := range credits {
+		if _, ok := debits[currency]; !ok {
+			return fmt.Errorf("%s not balanced in %s debits: 0 credits: %d", legs, currency, credit)
+		}
+	}
+	return nil
+}
+
+func checkConversion(stub shim.ChaincodeStubInterface, leg common.JournalLeg) error {
+
+	if (leg.Currency == "") || (leg.ToCurrency == "") || (leg.Currency == leg.ToCurrency) {
+		return errors.New("Conversion leg requires two different currencies: " + leg.Currency + "/" + leg.ToCurrency)
+	}
+	if leg.ConvertedAmt <= 0 {
+		return errors.New("Conversion leg converted amount must be greater than zero")
+	}
+
+	chaincodeArgs := toChaincodeArgs("getFXRate", leg.Currency, leg.ToCurrency, leg.RateDate)
+	response := stub.InvokeChaincode("fxratecc", chaincodeArgs, "myc")
+	if response.Status != shim.OK {
+		return errors.New("Conversion leg rate " + response.Message)
+	}
+	rate, err := strconv.ParseFloat(string(response.Payload), 64)
 	if err != nil {
-		return shim.Error("Error in Wallet updation " + err.Error())
+		return errors.New("Unable to parse the conversion rate " + err.Error())
 	}
-	fmt.Printf("Balance for %s : %f\n", args[0], bal.Balance)
-	return shim.Success(nil)
+
+	convertedAmt := int64(math.Round(float64(leg.Amt) * rate))
+	if convertedAmt != leg.ConvertedAmt {
+		return fmt.Errorf("Conversion leg %d %s should be %d %s at rate %s, given %d", leg.Amt, leg.Currency, convertedAmt, leg.ToCurrency, string(response.Payload), leg.ConvertedAmt)
+	}
+	return nil
+}
+
+func toChaincodeArgs(args ...string) [][]byte {
+	bargs := make([][]byte, len(args))
+	for i, arg := range args {
+		bargs[i] = []byte(arg)
+	}
+	return bargs
+}
+
+func migrateWallet(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	/*
+	*args -> WalletIDs to be migrated
+	 */
+	if len(args) == 0 {
+		return shim.Error("Invalid number of arguments in migrateWallet (required:atleast 1) given: 0")
+	}
+
+	migrated := 0
+	for _, walletID := range args {
+		bal, legacy, err := getWalletState(stub, walletID)
+		if err != nil {
+			return shim.Error("migrateWallet " + err.Error())
+		}
+		if !legacy {
+			continue
+		}
+		balBytes, _ := json.Marshal(bal)
+		err = stub.PutState(walletID, balBytes)
+		if err != nil {
+			return shim.Error("Error in Wallet migration " + err.Error())
+		}
+		fmt.Printf("Migrated wallet %s : %d %s\n", walletID, bal.Balance, bal.Currency)
+		migrated++
+	}
+	return shim.Success([]byte(strconv.Itoa(migrated)))
 }
 
 func main() {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	common "github.com/malo/EncoreBlockchain/chaincodes/Common"
+)
+
+func newWalletStub(t *testing.T, balances map[string]string) *shim.MockStub {
+	stub := shim.NewMockStub("walletcc", new(chainCode))
+	stub.MockTransactionStart("setup")
+	for walletID, bal := range balances {
+		response := newWallet(stub, []string{walletID, bal})
+		if response.Status != shim.OK {
+			t.Fatal(response.Message)
+		}
+	}
+	stub.MockTransactionEnd("setup")
+	return stub
+}
+
+func post(stub *shim.MockStub, txID string, legs []common.JournalLeg) ([]common.JournalResult, string) {
+	legsBytes, _ := json.Marshal(legs)
+	stub.MockTransactionStart(txID)
+	response := postJournal(stub, []string{string(legsBytes)})
+	stub.MockTransactionEnd(txID)
+	if response.Status != shim.OK {
+		return nil, response.Message
+	}
+	results := []common.JournalResult{}
+	json.Unmarshal(response.Payload, &results)
+	return results, ""
+}
+
+func balance(t *testing.T, stub *shim.MockStub, walletID string) string {
+	response := getWallet(stub, []string{walletID})
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	return string(response.Payload)
+}
+
+func TestPostJournal(t *testing.T) {
+
+	stub := newWalletStub(t, map[string]string{
+		"bankMain": "100000", "businessMain": "0",
+		"bankAsset": "0", "businessLoan": "0", "bankContra": "0",
+		"bankLiability": "0",
+	})
+
+	// A disbursement, the business loan mirrors the bank asset
+	results, msg := post(stub, "txn1", []common.JournalLeg{
+		{WalletID: "bankMain", Type: "debit", Amt: 40000},
+		{WalletID: "businessMain", Type: "credit", Amt: 40000},
+		{WalletID: "bankAsset", Type: "credit", Amt: 40000, Memo: true},
+		{WalletID: "businessLoan", Type: "credit", Amt: 40000, Memo: true, Contra: true},
+	})
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	if len(results) != 4 || results[0].OpeningBal != 100000 || results[0].TxnBal != 60000 || results[3].TxnBal != 40000 {
+		t.Fatalf("results %+v", results)
+	}
+
+	// The same wallet twice in a journal is read and written once
+	_, msg = post(stub, "txn2", []common.JournalLeg{
+		{WalletID: "bankMain", Type: "debit", Amt: 100},
+		{WalletID: "bankMain", Type: "debit", Amt: 200},
+		{WalletID: "businessMain", Type: "credit", Amt: 300},
+	})
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	if got := balance(t, stub, "bankMain"); got != "59700" {
+		t.Fatalf("bank main %s after two debits in one journal, want 59700", got)
+	}
+
+	rejected := []struct {
+		name string
+		legs []common.JournalLeg
+		msg  string
+	}{
+		{"unbalanced main legs", []common.JournalLeg{
+			{WalletID: "bankMain", Type: "debit", Amt: 100},
+			{WalletID: "businessMain", Type: "credit", Amt: 90},
+		}, "Journal is not balanced"},
+		{"memo legs only", []common.JournalLeg{
+			{WalletID: "bankAsset", Type: "credit", Amt: 100, Memo: true},
+		}, "Journal memo legs are not balanced"},
+		{"unbalanced memo legs", []common.JournalLeg{
+			{WalletID: "bankMain", Type: "debit", Amt: 100},
+			{WalletID: "businessMain", Type: "credit", Amt: 100},
+			{WalletID: "bankAsset", Type: "credit", Amt: 100, Memo: true},
+			{WalletID: "businessLoan", Type: "credit", Amt: 90, Memo: true, Contra: true},
+		}, "Journal memo legs are not balanced"},
+		{"memo legs balancing the main legs", []common.JournalLeg{
+			{WalletID: "bankMain", Type: "debit", Amt: 100},
+			{WalletID: "bankLiability", Type: "credit", Amt: 100, Memo: true},
+		}, "not balanced"},
+		{"contra main leg", []common.JournalLeg{
+			{WalletID: "bankMain", Type: "debit", Amt: 100},
+			{WalletID: "businessMain", Type: "debit", Amt: 100, Contra: true},
+		}, "Only a memo leg can be a contra leg"},
+		{"wallet missing", []common.JournalLeg{
+			{WalletID: "bankMain", Type: "debit", Amt: 100},
+			{WalletID: "", Type: "credit", Amt: 100},
+		}, "has no WalletId"},
+	}
+	for _, c := range rejected {
+		_, msg := post(stub, "rejected", c.legs)
+		if !strings.Contains(msg, c.msg) {
+			t.Errorf("%s: got %q, want %q", c.name, msg, c.msg)
+		}
+	}
+	for walletID, want := range map[string]string{"bankMain": "59700", "businessMain": "40300", "bankAsset": "40000", "businessLoan": "40000", "bankLiability": "0"} {
+		if got := balance(t, stub, walletID); got != want {
+			t.Errorf("%s is %s after the rejected journals, want %s", walletID, got, want)
+		}
+	}
+}